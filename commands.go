@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/username918r818/torrent-client/display"
+	"github.com/username918r818/torrent-client/torrent"
+)
+
+func runInfo(args []string) int {
+	fs := newFlagSet("info", "<file.torrent>", "Prints trackers, pieces and files of a torrent.")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "want one torrent file")
+	}
+	tf, err := readTorrent(fs.Arg(0))
+	if err != nil {
+		errorf("%v", err)
+		return exitError
+	}
+
+	fmt.Printf("Name:         %s\n", tf.Name())
+	fmt.Printf("Info hash:    %s\n", hex.EncodeToString(tf.InfoHash[:]))
+	fmt.Printf("Announce:     %s\n", tf.Announce)
+	for _, tracker := range tf.ReserveAnnounce {
+		fmt.Printf("Tracker:      %s\n", tracker)
+	}
+	fmt.Printf("Size:         %s (%d bytes)\n", display.Bytes(tf.Length()), tf.Length())
+	fmt.Printf("Piece length: %s\n", display.Bytes(tf.PieceLength))
+	fmt.Printf("Pieces:       %d\n", len(tf.Pieces))
+	fmt.Printf("Files:        %d\n", len(tf.Files))
+	for i, f := range tf.Files {
+		fmt.Printf("  %4d %10s  %s\n", i, display.Bytes(f.Length), strings.Join(f.Path, "/"))
+	}
+	return exitOK
+}
+
+// stringList is a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func runCreate(args []string) int {
+	fs := newFlagSet("create", "[flags] <file or directory>", "Creates a torrent file of a file or a directory.")
+	var trackers stringList
+	fs.Var(&trackers, "tracker", "announce URL, may be given several times; the first one is the main tracker")
+	pieceLength := fs.Int64("piece", 0, "KiB in a piece, a power of two; picked by the size if 0")
+	private := fs.Bool("private", false, "mark the torrent private")
+	comment := fs.String("comment", "", "comment of the torrent")
+	output := fs.String("o", "", "torrent file to write, <name>.torrent by default")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "want one file or directory")
+	}
+	if len(trackers) == 0 {
+		return usageError(fs, "at least one -tracker is needed")
+	}
+	if n := *pieceLength; n < 0 || n&(n-1) != 0 {
+		return usageError(fs, "-piece has to be a power of two")
+	}
+
+	root := fs.Arg(0)
+	data, err := torrent.Create(root, torrent.CreateOptions{
+		Trackers:    trackers,
+		PieceLength: *pieceLength << 10,
+		Private:     *private,
+		Comment:     *comment,
+	})
+	if err != nil {
+		errorf("%v", err)
+		return exitError
+	}
+	path := *output
+	if path == "" {
+		path = filepath.Base(filepath.Clean(root)) + ".torrent"
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		errorf("%v", err)
+		return exitError
+	}
+
+	tf, err := torrent.New(data)
+	if err != nil {
+		errorf("created torrent can't be read back: %v", err)
+		return exitError
+	}
+	fmt.Printf("Created %s: %d pieces of %s, info hash %s\n", path, len(tf.Pieces), display.Bytes(tf.PieceLength), hex.EncodeToString(tf.InfoHash[:]))
+	return exitOK
+}
+
+func runVerify(args []string) int {
+	fs := newFlagSet("verify", "[flags] <file.torrent>", "Hashes data of a torrent on disk. Exits with 4 if any data is missing or corrupt.")
+	dir := fs.String("dir", ".", "directory the torrent was downloaded to")
+	workers := fs.Int("workers", runtime.NumCPU(), "pieces hashed at once")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "want one torrent file")
+	}
+	if *workers < 1 {
+		return usageError(fs, "-workers has to be positive")
+	}
+	tf, err := readTorrent(fs.Arg(0))
+	if err != nil {
+		errorf("%v", err)
+		return exitError
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	res, err := torrent.Verify(ctx, &tf, *dir, *workers, nil)
+	if err != nil {
+		errorf("%v", err)
+		return exitError
+	}
+
+	fmt.Printf("%d of %d pieces are valid\n", res.Valid, res.Total)
+	for _, i := range res.Incomplete {
+		fmt.Printf("Missing or corrupt: %s\n", strings.Join(tf.Files[i].Path, "/"))
+	}
+	if res.Valid < res.Total {
+		return exitIncomplete
+	}
+	return exitOK
+}
+
+func runMagnet(args []string) int {
+	fs := newFlagSet("magnet", "<file.torrent>...", "Prints a magnet link of every torrent file.")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		return usageError(fs, "no torrent files")
+	}
+	code := exitOK
+	for _, path := range fs.Args() {
+		tf, err := readTorrent(path)
+		if err != nil {
+			errorf("%v", err)
+			code = exitError
+			continue
+		}
+		fmt.Println(tf.Magnet())
+	}
+	return code
+}
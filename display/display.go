@@ -0,0 +1,254 @@
+// Package display prints progress of torrents of a session to a terminal.
+package display
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/username918r818/torrent-client/torrent"
+)
+
+var (
+	LiveInterval  = time.Second      // between redraws of the live view
+	PlainInterval = 10 * time.Second // between plain lines
+	RateWindow    = 5 * time.Second  // rates are smoothed over about that long
+	MaxFiles      = 10               // files shown by the live view of a torrent
+)
+
+const barWidth = 30
+
+// IsTerminal reports whether f is a terminal, so the live view can be drawn.
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Run prints progress of torrents of s to out until ctx is done. The live view
+// redraws itself in place with ANSI escapes every LiveInterval, otherwise a
+// line per torrent is printed every PlainInterval.
+func Run(ctx context.Context, out io.Writer, s *torrent.Session, live bool) {
+	v := view{out: out, live: live, width: width(), rates: make(map[[20]byte]*rate)}
+	interval := PlainInterval
+	if live {
+		interval = LiveInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		v.draw(s.List(), time.Now())
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type view struct {
+	out   io.Writer
+	live  bool
+	width int
+	drawn int // lines of the last live frame, erased before the next one
+	rates map[[20]byte]*rate
+}
+
+func (v *view) draw(handles []*torrent.Handle, now time.Time) {
+	var lines []string
+	seen := make(map[[20]byte]bool, len(handles))
+	for _, h := range handles {
+		p := h.Progress()
+		r := v.rates[h.File.InfoHash]
+		if r == nil {
+			r = &rate{}
+			v.rates[h.File.InfoHash] = r
+		}
+		r.update(p.Received, p.Uploaded, now)
+		seen[h.File.InfoHash] = true
+
+		name := h.File.Name()
+		if v.live {
+			lines = append(lines, liveLines(name, p, r)...)
+		} else {
+			lines = append(lines, plainLine(name, p, r))
+		}
+	}
+	for hash := range v.rates {
+		if !seen[hash] {
+			delete(v.rates, hash)
+		}
+	}
+
+	var b strings.Builder
+	if v.live && v.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA\r\x1b[J", v.drawn)
+	}
+	for _, line := range lines {
+		if v.live {
+			line = truncate(line, v.width)
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	v.drawn = len(lines)
+	io.WriteString(v.out, b.String())
+}
+
+// rate follows transfer rates of a torrent from its byte counters.
+type rate struct {
+	received, uploaded int64
+	at                 time.Time
+	down, up           float64 // bytes per second
+}
+
+func (r *rate) update(received, uploaded int64, now time.Time) {
+	if r.at.IsZero() || received < r.received || uploaded < r.uploaded {
+		r.received, r.uploaded, r.at = received, uploaded, now
+		return
+	}
+	dt := now.Sub(r.at).Seconds()
+	if dt <= 0 {
+		return
+	}
+	alpha := min(1, dt/RateWindow.Seconds())
+	r.down += alpha * (float64(received-r.received)/dt - r.down)
+	r.up += alpha * (float64(uploaded-r.uploaded)/dt - r.up)
+	r.received, r.uploaded, r.at = received, uploaded, now
+}
+
+// eta returns time left to download wanted data, or -1 if it is unknown.
+func eta(p torrent.Progress, down float64) time.Duration {
+	left := p.Wanted - p.Saved
+	if left <= 0 {
+		return 0
+	}
+	if down < 1 {
+		return -1
+	}
+	seconds := float64(left) / down
+	if seconds > math.MaxInt64/float64(time.Second) {
+		return -1
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func liveLines(name string, p torrent.Progress, r *rate) []string {
+	lines := []string{
+		fmt.Sprintf("%s  %s", name, p.State),
+		fmt.Sprintf("  %s %5.1f%%  %s / %s", Bar(p.Saved, p.Wanted, barWidth), Percent(p.Saved, p.Wanted), Bytes(p.Saved), Bytes(p.Wanted)),
+		fmt.Sprintf("  down %s  up %s  eta %s  peers %d/%d", Rate(r.down), Rate(r.up), Duration(eta(p, r.down)), p.Peers, p.KnownPeers),
+		"  pieces: " + pieceStates(p.Pieces),
+	}
+	if len(p.Files) < 2 {
+		return lines
+	}
+	for i, f := range p.Files {
+		if i == MaxFiles {
+			lines = append(lines, fmt.Sprintf("  ... %d more files", len(p.Files)-MaxFiles))
+			break
+		}
+		status := fmt.Sprintf("%5.1f%%", Percent(f.Saved, f.Length))
+		if f.Skipped {
+			status = "  skip"
+		}
+		lines = append(lines, fmt.Sprintf("  %s %10s  %s", status, Bytes(f.Length), strings.Join(f.Path, "/")))
+	}
+	return lines
+}
+
+func plainLine(name string, p torrent.Progress, r *rate) string {
+	return fmt.Sprintf("%s: %s %.1f%% %s/%s down %s up %s eta %s peers %d/%d",
+		name, p.State, Percent(p.Saved, p.Wanted), Bytes(p.Saved), Bytes(p.Wanted),
+		Rate(r.down), Rate(r.up), Duration(eta(p, r.down)), p.Peers, p.KnownPeers)
+}
+
+// pieceStates lists numbers of pieces in states that have any.
+func pieceStates(counts [torrent.Corrupted + 1]int) string {
+	var parts []string
+	for state := torrent.Saved; state >= torrent.NotStarted; state-- {
+		if counts[state] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[state], state))
+		}
+	}
+	if counts[torrent.Corrupted] > 0 {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[torrent.Corrupted], torrent.Corrupted))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Bytes prints a size in binary units.
+func Bytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, i := float64(n)/1024, 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, units[i])
+}
+
+// Rate prints a rate in bytes per second.
+func Rate(bps float64) string {
+	return Bytes(int64(bps)) + "/s"
+}
+
+// Percent returns part of total in percent, an empty total is complete.
+func Percent(part, total int64) float64 {
+	if total <= 0 {
+		return 100
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+// Bar draws a progress bar of width characters between brackets.
+func Bar(part, total int64, width int) string {
+	filled := width
+	if total > 0 {
+		filled = int(part * int64(width) / total)
+	}
+	filled = max(0, min(width, filled))
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+// Duration prints d rounded to seconds like 1h02m03s, negative d is unknown.
+func Duration(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	d = d.Round(time.Second)
+	h, m, s := int(d/time.Hour), int(d%time.Hour/time.Minute), int(d%time.Minute/time.Second)
+	switch {
+	case h > 0:
+		return fmt.Sprintf("%dh%02dm%02ds", h, m, s)
+	case m > 0:
+		return fmt.Sprintf("%dm%02ds", m, s)
+	}
+	return fmt.Sprintf("%ds", s)
+}
+
+// truncate cuts line to width runes, so the terminal doesn't wrap it and the
+// number of drawn lines stays right.
+func truncate(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) <= width {
+		return line
+	}
+	return string(runes[:width])
+}
+
+// width returns columns of the terminal from COLUMNS, 80 by default.
+func width() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
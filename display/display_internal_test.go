@@ -0,0 +1,117 @@
+package display
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/torrent"
+)
+
+func TestFormat(t *testing.T) {
+	t.Run("bytes", func(t *testing.T) {
+		for n, want := range map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 3 << 30: "3.0 GiB"} {
+			if got := Bytes(n); got != want {
+				t.Fatalf("Bytes(%d) = %q, want %q", n, got, want)
+			}
+		}
+	})
+
+	t.Run("duration", func(t *testing.T) {
+		for d, want := range map[time.Duration]string{-1: "-", 0: "0s", 59 * time.Second: "59s", 61 * time.Second: "1m01s", 3723 * time.Second: "1h02m03s"} {
+			if got := Duration(d); got != want {
+				t.Fatalf("Duration(%v) = %q, want %q", d, got, want)
+			}
+		}
+	})
+
+	t.Run("bar", func(t *testing.T) {
+		if got := Bar(1, 4, 8); got != "[##......]" {
+			t.Fatalf("unexpected bar %q", got)
+		}
+		if got := Bar(0, 0, 2); got != "[##]" {
+			t.Fatalf("empty total should be complete, got %q", got)
+		}
+	})
+
+	t.Run("pieces", func(t *testing.T) {
+		var counts [torrent.Corrupted + 1]int
+		counts[torrent.NotStarted], counts[torrent.Saved], counts[torrent.Corrupted] = 3, 2, 1
+		if got := pieceStates(counts); got != "2 saved, 3 not started, 1 corrupted" {
+			t.Fatalf("unexpected pieces %q", got)
+		}
+	})
+}
+
+func TestRate(t *testing.T) {
+	RateWindow = time.Second
+	defer func() { RateWindow = 5 * time.Second }()
+
+	now := time.Now()
+	var r rate
+	r.update(100, 0, now)
+	if r.down != 0 {
+		t.Fatalf("first sample should only be remembered, got %v", r.down)
+	}
+	r.update(1100, 500, now.Add(time.Second))
+	if r.down != 1000 || r.up != 500 {
+		t.Fatalf("expected 1000 and 500 B/s, got %v and %v", r.down, r.up)
+	}
+	r.update(1100, 500, now.Add(1500*time.Millisecond))
+	if r.down != 500 {
+		t.Fatalf("expected rate to halve, got %v", r.down)
+	}
+
+	p := torrent.Progress{Wanted: 2000, Saved: 1000}
+	if got := eta(p, r.down); got != 2*time.Second {
+		t.Fatalf("expected eta of 2s, got %v", got)
+	}
+	if got := eta(p, 0); got != -1 {
+		t.Fatalf("expected unknown eta, got %v", got)
+	}
+}
+
+func TestView(t *testing.T) {
+	p := torrent.Progress{
+		State:  torrent.StateDownloading,
+		Wanted: 6, Saved: 3, Peers: 2, KnownPeers: 5,
+		Files: []torrent.FileProgress{
+			{Path: []string{"dir", "a"}, Length: 6, Saved: 3},
+			{Path: []string{"dir", "b"}, Length: 4, Skipped: true},
+		},
+	}
+	p.Pieces[torrent.Saved] = 1
+
+	t.Run("live", func(t *testing.T) {
+		lines := liveLines("dir", p, &rate{})
+		want := []string{
+			"dir  downloading",
+			"  [###############...............]  50.0%  3 B / 6 B",
+			"  down 0 B/s  up 0 B/s  eta -  peers 2/5",
+			"  pieces: 1 saved",
+			"   50.0%        6 B  dir/a",
+			"    skip        4 B  dir/b",
+		}
+		if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("unexpected lines:\n%s", strings.Join(lines, "\n"))
+		}
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		want := "dir: downloading 50.0% 3 B/6 B down 0 B/s up 0 B/s eta - peers 2/5"
+		if got := plainLine("dir", p, &rate{}); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("redraw", func(t *testing.T) {
+		var out bytes.Buffer
+		v := view{out: &out, live: true, width: 10, rates: make(map[[20]byte]*rate)}
+		v.drawn = 3
+		v.draw(nil, time.Now())
+		if out.String() != "\x1b[3A\r\x1b[J" || v.drawn != 0 {
+			t.Fatalf("expected previous frame to be erased, got %q", out.String())
+		}
+	})
+}
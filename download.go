@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/username918r818/torrent-client/display"
+	"github.com/username918r818/torrent-client/file"
+	"github.com/username918r818/torrent-client/limit"
+	"github.com/username918r818/torrent-client/stream"
+	"github.com/username918r818/torrent-client/torrent"
+)
+
+func runDownload(args []string) int {
+	fs := newFlagSet("download", "[flags] <file.torrent>...", "Downloads and seeds torrents until interrupted.")
+	dir := fs.String("dir", ".", "directory to download to, created if missing; resume data is kept there too")
+	httpAddr := fs.String("http", "", "address to stream files over HTTP from, e.g. localhost:8080")
+	mmap := fs.Bool("mmap", false, "write files through memory mapping")
+	alloc := fs.String("alloc", "sparse", "allocation of files: sparse, full or none")
+	partSuffix := fs.Bool("part", true, "write incomplete files with "+torrent.IncompleteSuffix+" suffix")
+	moveTo := fs.String("move", "", "directory to move the torrent to once it is complete, relative to -dir")
+	memory := fs.Int64("memory", 256, "MiB of memory for pieces not saved yet, 0 means no limit")
+	cacheSize := fs.Int64("cache", 64, "MiB of memory for pieces read from disk, 0 disables the cache")
+	maxOpen := fs.Int("maxopen", file.DefaultMaxOpen, "maximum number of files kept open at once")
+	port := fs.Int("port", 1488, "port announced to trackers")
+	maxPeers := fs.Int("peers", 0, "maximum number of peer connections of all torrents, 0 means no limit")
+	downloads := fs.Int("downloads", 0, "maximum number of torrents downloading at once, 0 means no limit")
+	seeds := fs.Int("seeds", 0, "maximum number of torrents seeding at once, 0 means no limit")
+	stall := fs.Duration("stall", 0, "downloads without data for that long don't count against -downloads, 0 turns it off")
+	ratio := fs.Float64("ratio", 0, "stop seeding once uploaded is that many times downloaded, 0 means no limit; not reached while uploads are not served")
+	seedTime := fs.Duration("seedtime", 0, "stop seeding after seeding for that long, 0 means no limit")
+	seedAction := fs.String("seedaction", "pause", "what to do once seeding is stopped: pause, remove or delete")
+	downLimit := fs.Int64("down", 0, "KiB/s of download of all torrents, 0 means no limit")
+	upLimit := fs.Int64("up", 0, "KiB/s of upload of all torrents, 0 means no limit")
+	altDown := fs.Int64("altdown", 0, "KiB/s of download while alternative limits are on, 0 means no limit")
+	altUp := fs.Int64("altup", 0, "KiB/s of upload while alternative limits are on, 0 means no limit")
+	altSchedule := fs.String("altschedule", "", "when alternative limits are on, e.g. \"mon-fri 09:00-18:00; sat,sun 23:00-07:00\"")
+	alt := fs.String("alt", "auto", "alternative limits: auto (by -altschedule), on or off")
+	defaults := torrent.DefaultConfig()
+	configPath := fs.String("config", "", "JSON file with settings of workers, flags below override it")
+	torrentPeers := fs.Int("torrentpeers", defaults.Peers, "peer connections of a torrent")
+	fileWorkers := fs.Int("fileworkers", defaults.FileWorkers, "file workers shared by all torrents")
+	pieceWorkers := fs.Int("pieceworkers", defaults.PieceWorkers, "piece workers of a torrent")
+	reconnect := fs.Duration("reconnect", defaults.ReconnectDelay, "delay before a dropped peer is connected again")
+	peerTimeout := fs.Duration("peertimeout", defaults.PeerTimeout, "time to read or write a peer message")
+	interested := fs.Duration("interested", defaults.InterestedDelay, "delay between the bitfield of a peer and the interested message")
+	progress := fs.String("progress", "auto", "progress output: live, plain lines, off, or auto for live on a terminal")
+	logPath := fs.String("log", "", "file to write logs to instead of stderr, torrent-client.log in -dir while progress is live")
+	shutdown := fs.Duration("shutdown", defaults.ShutdownTimeout, "time given to torrents to write their data on exit")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() == 0 {
+		return usageError(fs, "no torrent files")
+	}
+
+	switch *progress {
+	case "auto":
+		*progress = "plain"
+		if display.IsTerminal(os.Stdout) {
+			*progress = "live"
+		}
+	case "live", "plain", "off":
+	default:
+		return usageError(fs, "wrong -progress: %q", *progress)
+	}
+	allocMode, err := file.ParseAllocMode(*alloc)
+	if err != nil {
+		return usageError(fs, "wrong -alloc: %v", err)
+	}
+	altMode, err := torrent.ParseAltMode(*alt)
+	if err != nil {
+		return usageError(fs, "wrong -alt: %v", err)
+	}
+	var schedule limit.Schedule
+	if *altSchedule != "" {
+		if schedule, err = limit.ParseSchedule(*altSchedule); err != nil {
+			return usageError(fs, "wrong -altschedule: %v", err)
+		}
+	}
+	if *ratio > 0 {
+		errorf("warning: -ratio is never reached, uploads are not served yet; use -seedtime to stop seeding")
+	}
+	seedLimits := torrent.SeedLimits{Ratio: *ratio, Time: *seedTime}
+	if seedLimits.Action, err = torrent.ParseSeedAction(*seedAction); err != nil {
+		return usageError(fs, "wrong -seedaction: %v", err)
+	}
+	cfg := defaults
+	if *configPath != "" {
+		if err := torrent.LoadConfig(*configPath, &cfg); err != nil {
+			return usageError(fs, "wrong -config: %v", err)
+		}
+	}
+	// only flags given on the command line override the file
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "torrentpeers":
+			cfg.Peers = *torrentPeers
+		case "fileworkers":
+			cfg.FileWorkers = *fileWorkers
+		case "pieceworkers":
+			cfg.PieceWorkers = *pieceWorkers
+		case "reconnect":
+			cfg.ReconnectDelay = *reconnect
+		case "peertimeout":
+			cfg.PeerTimeout = *peerTimeout
+		case "interested":
+			cfg.InterestedDelay = *interested
+		case "shutdown":
+			cfg.ShutdownTimeout = *shutdown
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		return usageError(fs, "wrong config: %v", err)
+	}
+
+	// torrent files are given relative to the current directory, data goes to -dir
+	var torrentFiles []torrent.TorrentFile
+	for _, path := range fs.Args() {
+		tf, err := readTorrent(path)
+		if err != nil {
+			errorf("%v", err)
+			return exitError
+		}
+		torrentFiles = append(torrentFiles, tf)
+	}
+	if *logPath != "" {
+		if *logPath, err = filepath.Abs(*logPath); err != nil {
+			errorf("wrong -log: %v", err)
+			return exitError
+		}
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		errorf("can't create -dir: %v", err)
+		return exitError
+	}
+	if err := os.Chdir(*dir); err != nil {
+		errorf("can't enter -dir: %v", err)
+		return exitError
+	}
+	// the live view would be broken by log lines on the terminal
+	if *logPath == "" && *progress == "live" {
+		if *logPath, err = filepath.Abs("torrent-client.log"); err != nil {
+			errorf("can't find log file: %v", err)
+			return exitError
+		}
+	}
+	if *logPath != "" {
+		f, err := os.OpenFile(*logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			errorf("can't open -log: %v", err)
+			return exitError
+		}
+		defer f.Close()
+		slog.SetDefault(slog.New(slog.NewTextHandler(f, nil)))
+		if *progress == "live" {
+			fmt.Printf("Logs are written to %s\n", *logPath)
+		}
+	}
+
+	// the first signal stops torrents gracefully, the second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	session := torrent.NewSession(ctx, *port, cfg)
+	displayDone := make(chan struct{})
+	go func() {
+		defer close(displayDone)
+		if *progress != "off" {
+			display.Run(ctx, os.Stdout, session, *progress == "live")
+		}
+	}()
+	// wait stops all torrents and returns the exit code
+	wait := func(code int) int {
+		cancel()
+		stop()
+		<-displayDone
+		fmt.Println("Stopping, waiting for data to be written")
+		done := make(chan error, 1)
+		go func() { done <- session.Wait() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				errorf("stopped with errors: %v", err)
+				return exitError
+			}
+			return code
+		case <-time.After(cfg.ShutdownTimeout + torrent.AnnounceTimeout):
+			errorf("torrents are not stopped in time")
+			return exitTimeout
+		}
+	}
+
+	session.SetMaxPeers(*maxPeers)
+	session.SetQueueLimits(*downloads, *seeds)
+	session.SetStallTime(*stall)
+	session.SetSeedLimits(seedLimits)
+	session.SetDownloadLimit(*downLimit << 10)
+	session.SetUploadLimit(*upLimit << 10)
+	session.SetAltLimits(*altDown<<10, *altUp<<10)
+	session.SetAltSchedule(schedule)
+	session.SetAltMode(altMode)
+	cache := torrent.NewReadCache(*cacheSize << 20)
+	server := stream.NewServer()
+	server.SetSession(session)
+
+	for _, tf := range torrentFiles {
+		h := torrent.NewHandle(tf)
+		h.SetAllocMode(allocMode)
+		h.SetIncompleteSuffix(*partSuffix)
+		h.SetMoveTo(*moveTo)
+		h.Pieces().SetMemoryBudget(*memory << 20)
+		h.SetReadCache(cache)
+		if *mmap {
+			storage := file.NewMmap()
+			storage.SetMaxOpen(*maxOpen)
+			h.SetStorage(storage)
+		} else {
+			storage := file.NewDisk()
+			storage.SetMaxOpen(*maxOpen)
+			h.SetStorage(storage)
+		}
+
+		if err := session.Add(h); err != nil {
+			errorf("can't add torrent: %v", err)
+			return wait(exitError)
+		}
+		server.Add(h)
+	}
+
+	if *httpAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(*httpAddr, server); err != nil {
+				errorf("can't serve HTTP: %v", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	return wait(exitOK)
+}
@@ -0,0 +1,47 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// preallocate reserves blocks for the whole file, filesystems without
+// fallocate get a sparse file instead.
+func preallocate(f *os.File, length int64) error {
+	if length == 0 {
+		return f.Truncate(0)
+	}
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, length)
+	if errors.Is(err, syscall.EOPNOTSUPP) {
+		return f.Truncate(length)
+	}
+	return err
+}
+
+// allocated returns how many bytes of a file are really on disk, holes of
+// sparse files are not counted.
+func allocated(info os.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Blocks * 512
+	}
+	return info.Size()
+}
+
+// freeSpace returns bytes available to the user on the filesystem of path,
+// path itself may not exist yet.
+func freeSpace(path string) (int64, error) {
+	for {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(path, &st)
+		if err == nil {
+			return int64(st.Bavail) * st.Bsize, nil
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, syscall.ENOENT) || parent == path {
+			return 0, err
+		}
+		path = parent
+	}
+}
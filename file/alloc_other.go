@@ -0,0 +1,21 @@
+//go:build !linux
+
+package file
+
+import (
+	"errors"
+	"os"
+)
+
+// preallocate gives a sparse file, there is no portable way to reserve blocks.
+func preallocate(f *os.File, length int64) error {
+	return f.Truncate(length)
+}
+
+func allocated(info os.FileInfo) int64 {
+	return info.Size()
+}
+
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("free space is unknown on this system")
+}
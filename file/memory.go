@@ -0,0 +1,58 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Memory keeps files in memory, it is meant for tests. Files are created by
+// the first write and forgotten by Close.
+type Memory struct {
+	mu    sync.Mutex
+	files map[Key][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{files: make(map[Key][]byte)}
+}
+
+func (m *Memory) ReadAt(key Key, p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[key]
+	if !ok {
+		return 0, fmt.Errorf("storage: file %d is not opened", key.Index)
+	}
+	if off >= int64(len(data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *Memory) WriteAt(key Key, p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data := m.files[key]
+	if end := off + int64(len(p)); end > int64(len(data)) {
+		data = append(data, make([]byte, end-int64(len(data)))...)
+	}
+	copy(data[off:], p)
+	m.files[key] = data
+	return len(p), nil
+}
+
+func (m *Memory) Sync(key Key) error {
+	return nil
+}
+
+func (m *Memory) Close(key Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, key)
+	return nil
+}
@@ -0,0 +1,133 @@
+package file
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// DirectWriter is implemented by storages which writes are plain memory
+// copies. The file worker writes pieces to them one by one instead of joining
+// them into a new buffer first.
+type DirectWriter interface {
+	Storage
+	Direct(key Key) bool
+}
+
+// Mmap maps files into memory, validated data is copied straight into the
+// mapped region and synced with msync. Files that can't be mapped (empty ones,
+// the part file that grows as written, or any file where mmap is not
+// available) are read and written as files on disk.
+type Mmap struct {
+	disk *Disk
+	mu   sync.RWMutex // read locked while a mapping is used, Close unmaps under the write lock
+	maps map[Key][]byte
+}
+
+func NewMmap() *Mmap {
+	return &Mmap{disk: NewDisk(), maps: make(map[Key][]byte)}
+}
+
+// SetMaxOpen limits how many files are kept open at once, mapped files don't
+// need to stay open.
+func (m *Mmap) SetMaxOpen(n int) {
+	m.disk.SetMaxOpen(n)
+}
+
+// Add makes an opened file available under key and maps it if possible.
+func (m *Mmap) Add(key Key, f *os.File) {
+	m.disk.Add(key, f)
+	m.mapFile(key, f)
+}
+
+// AddPath makes the existing file at path available under key and maps it if
+// possible. A file that is mapped already is only opened by the new path.
+func (m *Mmap) AddPath(key Key, path string) {
+	m.disk.AddPath(key, path)
+	if m.Direct(key) {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		slog.Info("Mmap: file is not mapped: " + err.Error())
+		return
+	}
+	// the mapping stays valid after the file is closed
+	defer f.Close()
+	m.mapFile(key, f)
+}
+
+func (m *Mmap) mapFile(key Key, f *os.File) {
+	data, err := mapFile(f)
+	if err != nil {
+		slog.Info("Mmap: file is not mapped: " + err.Error())
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maps[key] = data
+}
+
+func (m *Mmap) Direct(key Key) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.maps[key] != nil
+}
+
+// mapped calls use with the mapping of key if bytes from off to end are
+// mapped, the mapping is not unmapped until use returns.
+func (m *Mmap) mapped(key Key, off, end int64, use func(data []byte)) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data := m.maps[key]
+	if data == nil || end > int64(len(data)) {
+		return false
+	}
+	use(data[off:])
+	return true
+}
+
+func (m *Mmap) ReadAt(key Key, p []byte, off int64) (int, error) {
+	var n int
+	if m.mapped(key, off, off+int64(len(p)), func(data []byte) { n = copy(p, data) }) {
+		return n, nil
+	}
+	return m.disk.ReadAt(key, p, off)
+}
+
+func (m *Mmap) WriteAt(key Key, p []byte, off int64) (int, error) {
+	var n int
+	if m.mapped(key, off, off+int64(len(p)), func(data []byte) { n = copy(data, p) }) {
+		return n, nil
+	}
+	return m.disk.WriteAt(key, p, off)
+}
+
+func (m *Mmap) Sync(key Key) error {
+	var err error
+	m.mapped(key, 0, 0, func(data []byte) { err = syncMap(data) })
+	if err != nil {
+		return err
+	}
+	return m.disk.Sync(key)
+}
+
+func (m *Mmap) Close(key Key) error {
+	// readers and writers of the mapping are waited for before it is unmapped
+	m.mu.Lock()
+	data := m.maps[key]
+	delete(m.maps, key)
+	var err error
+	if data != nil {
+		err = syncMap(data)
+		if unmapErr := unmapFile(data); err == nil {
+			err = unmapErr
+		}
+	}
+	m.mu.Unlock()
+	if err != nil {
+		m.disk.Close(key)
+		return err
+	}
+	return m.disk.Close(key)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package file
+
+import (
+	"errors"
+	"os"
+)
+
+func mapFile(f *os.File) ([]byte, error) {
+	return nil, errors.New("mmap is not supported on this system")
+}
+
+func syncMap(data []byte) error {
+	return nil
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,62 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Move moves a file to dst, creating its directories. Files on another
+// filesystem are copied and synced first, then the original is removed.
+func Move(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("Move: %w", err)
+	}
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		if err != nil {
+			return fmt.Errorf("Move: %w", err)
+		}
+		return nil
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return fmt.Errorf("Move: %w", err)
+	}
+	if err := os.Remove(src); err != nil {
+		return fmt.Errorf("Move: %w", err)
+	}
+	return nil
+}
+
+// copyFile copies src next to dst and renames it, so dst never has half of data.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package file
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Router passes calls to storages of torrents by info hash of the key, so file
+// workers can be shared by torrents with different storages.
+type Router struct {
+	mu       sync.Mutex
+	storages map[[20]byte]Storage
+}
+
+func NewRouter() *Router {
+	return &Router{storages: make(map[[20]byte]Storage)}
+}
+
+// Set routes keys of the torrent to s.
+func (r *Router) Set(infoHash [20]byte, s Storage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.storages[infoHash] = s
+}
+
+// Remove forgets the storage of the torrent, its files are not closed.
+func (r *Router) Remove(infoHash [20]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.storages, infoHash)
+}
+
+func (r *Router) get(key Key) (Storage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.storages[key.InfoHash]
+	if !ok {
+		return nil, fmt.Errorf("storage: torrent %x is not added", key.InfoHash)
+	}
+	return s, nil
+}
+
+func (r *Router) Direct(key Key) bool {
+	s, err := r.get(key)
+	if err != nil {
+		return false
+	}
+	d, ok := s.(DirectWriter)
+	return ok && d.Direct(key)
+}
+
+func (r *Router) ReadAt(key Key, p []byte, off int64) (int, error) {
+	s, err := r.get(key)
+	if err != nil {
+		return 0, err
+	}
+	return s.ReadAt(key, p, off)
+}
+
+func (r *Router) WriteAt(key Key, p []byte, off int64) (int, error) {
+	s, err := r.get(key)
+	if err != nil {
+		return 0, err
+	}
+	return s.WriteAt(key, p, off)
+}
+
+func (r *Router) Sync(key Key) error {
+	s, err := r.get(key)
+	if err != nil {
+		return err
+	}
+	return s.Sync(key)
+}
+
+func (r *Router) Close(key Key) error {
+	s, err := r.get(key)
+	if err != nil {
+		return err
+	}
+	return s.Close(key)
+}
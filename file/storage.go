@@ -0,0 +1,221 @@
+package file
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// PartIndex is the file index of the part file of a torrent, it keeps data of
+// skipped files at torrent offsets.
+const PartIndex = -1
+
+const DefaultMaxOpen = 256 // open files of a Disk unless SetMaxOpen is called
+
+var IdleTimeout = time.Minute // files not used for this long are closed
+
+// Key addresses a file of a torrent in storage.
+type Key struct {
+	InfoHash [20]byte
+	Index    int
+}
+
+// Storage keeps data of torrent files. File workers write validated data to it
+// and readers read it back, the rest of the client does not know where data lives.
+type Storage interface {
+	ReadAt(key Key, p []byte, off int64) (int, error)
+	WriteAt(key Key, p []byte, off int64) (int, error)
+	Sync(key Key) error
+	Close(key Key) error
+}
+
+// handle is an open file of a Disk.
+type handle struct {
+	key  Key
+	f    *os.File
+	refs int // operations using the file at the moment, it is not closed while used
+	used time.Time
+}
+
+// Disk is the default storage, every file of a torrent is a file on disk.
+// Files are opened on first use and kept in a pool of at most maxOpen open
+// files: the least recently used one is closed to open another, and files
+// that are idle for IdleTimeout are closed too.
+type Disk struct {
+	mu      sync.Mutex // guards fields below
+	freed   sync.Cond  // signaled when a file is not used anymore
+	paths   map[Key]string
+	open    map[Key]*list.Element
+	order   *list.List // of *handle, most recently used first
+	maxOpen int
+	sweep   *time.Timer // closes idle files while some are open
+}
+
+func NewDisk() *Disk {
+	d := &Disk{paths: make(map[Key]string), open: make(map[Key]*list.Element), order: list.New(), maxOpen: DefaultMaxOpen}
+	d.freed.L = &d.mu
+	return d
+}
+
+// SetMaxOpen limits how many files are kept open at once.
+func (d *Disk) SetMaxOpen(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxOpen = max(n, 1)
+	d.closeUnused(func(*handle) bool { return d.order.Len() > d.maxOpen })
+}
+
+// OpenFiles returns how many files are open at the moment.
+func (d *Disk) OpenFiles() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.order.Len()
+}
+
+// AddPath makes the existing file at path available under key, it is opened
+// when it is used.
+func (d *Disk) AddPath(key Key, path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paths[key] = path
+}
+
+// Add makes an opened file available under key. It is closed by Close or once
+// it is idle, and opened again by its name for reading and writing.
+func (d *Disk) Add(key Key, f *os.File) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paths[key] = f.Name()
+	d.push(&handle{key: key, f: f, used: time.Now()})
+}
+
+// push adds h to open files, closing least recently used ones above the
+// limit. mu has to be held.
+func (d *Disk) push(h *handle) {
+	d.open[h.key] = d.order.PushFront(h)
+	d.closeUnused(func(*handle) bool { return d.order.Len() > d.maxOpen })
+	if d.sweep == nil {
+		d.sweep = time.AfterFunc(IdleTimeout, d.closeIdle)
+	}
+}
+
+// closeUnused closes files not used at the moment, least recently used first,
+// while more returns true. mu has to be held.
+func (d *Disk) closeUnused(more func(*handle) bool) {
+	for e := d.order.Back(); e != nil; {
+		h := e.Value.(*handle)
+		if !more(h) {
+			return
+		}
+		prev := e.Prev()
+		if h.refs == 0 {
+			d.order.Remove(e)
+			delete(d.open, h.key)
+			h.f.Close()
+		}
+		e = prev
+	}
+}
+
+func (d *Disk) closeIdle() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	idle := time.Now().Add(-IdleTimeout)
+	d.closeUnused(func(h *handle) bool { return h.used.Before(idle) })
+	if d.order.Len() > 0 {
+		d.sweep.Reset(IdleTimeout)
+	} else {
+		d.sweep = nil
+	}
+}
+
+// acquire returns the open file of key, opening it if needed. The file is
+// not closed until it is released.
+func (d *Disk) acquire(key Key) (*handle, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		if e, ok := d.open[key]; ok {
+			h := e.Value.(*handle)
+			h.refs++
+			h.used = time.Now()
+			d.order.MoveToFront(e)
+			return h, nil
+		}
+		path, ok := d.paths[key]
+		if !ok {
+			return nil, fmt.Errorf("storage: file %d is not added", key.Index)
+		}
+		d.closeUnused(func(*handle) bool { return d.order.Len() >= d.maxOpen })
+		if d.order.Len() < d.maxOpen {
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				return nil, fmt.Errorf("storage: %w", err)
+			}
+			h := &handle{key: key, f: f, refs: 1, used: time.Now()}
+			d.push(h)
+			return h, nil
+		}
+		// every open file is used, the key may be added or closed meanwhile
+		d.freed.Wait()
+	}
+}
+
+func (d *Disk) release(h *handle) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h.refs--
+	if h.refs == 0 {
+		d.freed.Broadcast()
+	}
+}
+
+func (d *Disk) ReadAt(key Key, p []byte, off int64) (int, error) {
+	h, err := d.acquire(key)
+	if err != nil {
+		return 0, err
+	}
+	defer d.release(h)
+	return h.f.ReadAt(p, off)
+}
+
+func (d *Disk) WriteAt(key Key, p []byte, off int64) (int, error) {
+	h, err := d.acquire(key)
+	if err != nil {
+		return 0, err
+	}
+	defer d.release(h)
+	return h.f.WriteAt(p, off)
+}
+
+func (d *Disk) Sync(key Key) error {
+	h, err := d.acquire(key)
+	if err != nil {
+		return err
+	}
+	defer d.release(h)
+	return h.f.Sync()
+}
+
+// Close closes the file of key and forgets it, it waits until the file is not used.
+func (d *Disk) Close(key Key) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.paths, key)
+	for {
+		e, ok := d.open[key]
+		if !ok {
+			return nil
+		}
+		h := e.Value.(*handle)
+		if h.refs == 0 {
+			d.order.Remove(e)
+			delete(d.open, key)
+			d.freed.Broadcast()
+			return h.f.Close()
+		}
+		d.freed.Wait()
+	}
+}
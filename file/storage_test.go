@@ -0,0 +1,146 @@
+package file_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/file"
+)
+
+func TestStorage(t *testing.T) {
+	key := file.Key{InfoHash: [20]byte{1}, Index: 2}
+
+	disk := file.NewDisk()
+	f, err := os.Create(filepath.Join(t.TempDir(), "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk.Add(key, f)
+
+	// empty file can't be mapped, it is written as usual
+	mmap := file.NewMmap()
+	empty, err := os.Create(filepath.Join(t.TempDir(), "empty.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mmap.Add(key, empty)
+
+	memory := file.NewMemory()
+	if _, err := memory.WriteAt(key, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, s := range map[string]file.Storage{"disk": disk, "mmap": mmap, "memory": memory} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.WriteAt(key, []byte("world"), 6); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := s.WriteAt(key, []byte("hello "), 0); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := s.Sync(key); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			buf := make([]byte, 5)
+			if n, err := s.ReadAt(key, buf, 6); err != nil || string(buf[:n]) != "world" {
+				t.Fatalf("expected world, got %q, %v", buf[:n], err)
+			}
+			if n, err := s.ReadAt(key, buf, 8); err != io.EOF || string(buf[:n]) != "rld" {
+				t.Fatalf("expected rld and EOF, got %q, %v", buf[:n], err)
+			}
+
+			if _, err := s.ReadAt(file.Key{Index: 3}, buf, 0); err == nil {
+				t.Fatal("expected error for unknown file, but got none")
+			}
+
+			if err := s.Close(key); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if _, err := s.ReadAt(key, buf, 0); err == nil {
+				t.Fatal("expected error for closed file, but got none")
+			}
+		})
+	}
+}
+
+func TestDiskPool(t *testing.T) {
+	idleTimeout := file.IdleTimeout
+	t.Cleanup(func() { file.IdleTimeout = idleTimeout })
+	file.IdleTimeout = 20 * time.Millisecond
+
+	dir := t.TempDir()
+	disk := file.NewDisk()
+	disk.SetMaxOpen(2)
+	for i := range 3 {
+		path := filepath.Join(dir, fmt.Sprintf("%d.txt", i))
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		disk.AddPath(file.Key{Index: i}, path)
+	}
+	if n := disk.OpenFiles(); n != 0 {
+		t.Fatalf("expected files to be opened on first use, got %d open", n)
+	}
+
+	for i := range 3 {
+		if _, err := disk.WriteAt(file.Key{Index: i}, []byte{byte('a' + i)}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := disk.OpenFiles(); n != 2 {
+		t.Fatalf("expected 2 open files, got %d", n)
+	}
+
+	// the first file was closed and is opened again
+	buf := make([]byte, 1)
+	if _, err := disk.ReadAt(file.Key{Index: 0}, buf, 0); err != nil || buf[0] != 'a' {
+		t.Fatalf("expected a, got %q, %v", buf, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for disk.OpenFiles() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected idle files to be closed, got %d open", disk.OpenFiles())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMmapClose(t *testing.T) {
+	key := file.Key{Index: 1}
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(path, make([]byte, 16<<20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mmap := file.NewMmap()
+	mmap.AddPath(key, path)
+	if !mmap.Direct(key) {
+		t.Skip("file is not mapped")
+	}
+
+	// readers running while the file is closed must not touch unmapped memory
+	done := make(chan struct{})
+	for range 4 {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			buf := make([]byte, 16<<20)
+			for {
+				if _, err := mmap.ReadAt(key, buf, 0); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := mmap.Close(key); err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		<-done
+	}
+}
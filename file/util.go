@@ -3,47 +3,112 @@ package file
 import (
 	"errors"
 	"fmt"
-	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
+// AllocMode selects how space for files is allocated.
+type AllocMode int
+
+const (
+	AllocSparse AllocMode = iota // files are truncated to full size, most filesystems leave holes
+	AllocFull                    // space is reserved up front (fallocate on Linux) to avoid fragmentation
+	AllocNone                    // files grow as data is written
+)
+
+var allocModes = []string{"sparse", "full", "none"}
+
+func (m AllocMode) String() string {
+	if m < 0 || int(m) >= len(allocModes) {
+		return "unknown"
+	}
+	return allocModes[m]
+}
+
+func ParseAllocMode(s string) (AllocMode, error) {
+	for i, name := range allocModes {
+		if name == s {
+			return AllocMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown allocation mode %q", s)
+}
+
+// Alloc creates files and directories of a torrent. Existing data is kept, it
+// could be restored from resume data. It fails early if there is not enough
+// free space for the rest of the data. Files are closed once they are sized.
 func Alloc(files []struct {
 	Length int64
 	Path   []string
-}) (map[string]*os.File, error) {
-	m := make(map[string]*os.File, len(files))
+}, mode AllocMode) error {
+	var need int64
 	for _, f := range files {
 		if len(f.Path) == 0 {
-			return nil, errors.New("Alloc: file.Path == 0")
+			return errors.New("Alloc: file.Path == 0")
+		}
+		need += f.Length
+		if info, err := os.Stat(filepath.Join(f.Path...)); err == nil {
+			need -= min(allocated(info), f.Length)
+		}
+	}
+	// files of a torrent are in one directory, so all of them are on one filesystem
+	if len(files) > 0 {
+		if free, err := freeSpace(filepath.Join(files[0].Path...)); err == nil && free < need {
+			return fmt.Errorf("Alloc: not enough free space: need %d bytes, %d available", need, free)
 		}
+	}
+
+	for _, f := range files {
 		var filePath string
 		if len(f.Path) > 1 {
 			dirPath := filepath.Join(f.Path[:len(f.Path)-1]...)
 			err := os.MkdirAll(dirPath, 0755)
 			if err != nil {
-				return nil, fmt.Errorf("Alloc: %w", err)
+				return fmt.Errorf("Alloc: %w", err)
 			}
 			filePath = strings.Join(f.Path, "/")
 		} else {
 			filePath = f.Path[0]
 		}
-		slog.Info(filePath)
-		slog.Info(f.Path[0])
-		file, err := os.Create(filePath)
-		if err != nil {
-			return nil, fmt.Errorf("Alloc: %w", err)
+		if err := allocFile(filePath, f.Length, mode); err != nil {
+			return fmt.Errorf("Alloc: %w", err)
 		}
+	}
+
+	return nil
+}
 
-		err = file.Truncate(int64(f.Length))
-		if err != nil {
-			return nil, fmt.Errorf("Alloc: %w", err)
+func allocFile(path string, length int64, mode AllocMode) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// resizing a file of right size would still touch its modification time
+	if info.Size() != length && mode != AllocNone {
+		if mode == AllocFull {
+			return preallocate(file, length)
 		}
-		m[filePath] = file
+		return file.Truncate(length)
 	}
+	return nil
+}
 
-	return m, nil
+// CreatePart creates part file if it does not exist, without truncating it. It
+// is not sized, data is written at torrent offsets and the rest of it stays a hole.
+func CreatePart(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return fmt.Errorf("CreatePart: %w", err)
+	}
+	return file.Close()
 }
 
 func WriteChunk(file *os.File, offset int64, data []byte) error {
@@ -2,8 +2,10 @@ package file_test
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/username918r818/torrent-client/file"
@@ -22,7 +24,7 @@ func TestAlloc(t *testing.T) {
 			{Length: 1024, Path: []string{tempDir, "file1.txt"}},
 		}
 
-		_, err := file.Alloc(files)
+		err := file.Alloc(files, file.AllocSparse)
 		if err != nil {
 			t.Fatalf("expected no error, got %v", err)
 		}
@@ -40,7 +42,7 @@ func TestAlloc(t *testing.T) {
 			{Length: 1024, Path: []string{}},
 		}
 
-		_, err := file.Alloc(files)
+		err := file.Alloc(files, file.AllocSparse)
 		if err == nil {
 			t.Fatal("expected error, but got none")
 		}
@@ -57,7 +59,7 @@ func TestAlloc(t *testing.T) {
 			{Length: 1024, Path: []string{"/invalid:dir", "file1.txt"}},
 		}
 
-		_, err := file.Alloc(files)
+		err := file.Alloc(files, file.AllocSparse)
 		if err == nil {
 			t.Fatal("expected error, but got none")
 		}
@@ -74,7 +76,7 @@ func TestAlloc(t *testing.T) {
 			{Length: 1024, Path: []string{nestedDir, "file1.txt"}},
 		}
 
-		_, err := file.Alloc(files)
+		err := file.Alloc(files, file.AllocSparse)
 		if err != nil {
 			t.Fatalf("expected no error, but got %v", err)
 		}
@@ -109,7 +111,7 @@ func TestAlloc(t *testing.T) {
 				{Length: 1024, Path: []string{nestedDirs[3].Path, nestedDirs[3].File}},
 			}
 
-			_, err := file.Alloc(files)
+			err := file.Alloc(files, file.AllocSparse)
 			if err != nil {
 				t.Fatalf("expected no error, but got %v", err)
 			}
@@ -129,6 +131,65 @@ func TestAlloc(t *testing.T) {
 	})
 }
 
+func TestAllocModes(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for _, name := range []string{"sparse", "full", "none"} {
+		t.Run(name, func(t *testing.T) {
+			mode, err := file.ParseAllocMode(name)
+			if err != nil || mode.String() != name {
+				t.Fatalf("expected mode %s, got %v, %v", name, mode, err)
+			}
+
+			files := []struct {
+				Length int64
+				Path   []string
+			}{
+				{Length: 1024, Path: []string{tempDir, name + ".txt"}},
+			}
+			if err := file.Alloc(files, mode); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			info, err := os.Stat(filepath.Join(tempDir, name+".txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := int64(1024)
+			if mode == file.AllocNone {
+				want = 0
+			}
+			if info.Size() != want {
+				t.Fatalf("expected size %d, got %d", want, info.Size())
+			}
+		})
+	}
+
+	t.Run("unknown mode", func(t *testing.T) {
+		if _, err := file.ParseAllocMode("dense"); err == nil {
+			t.Fatal("expected error, but got none")
+		}
+	})
+
+	t.Run("not enough space", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("free space is only checked on linux")
+		}
+		files := []struct {
+			Length int64
+			Path   []string
+		}{
+			{Length: 1 << 62, Path: []string{tempDir, "huge", "file.txt"}},
+		}
+		if err := file.Alloc(files, file.AllocSparse); err == nil {
+			t.Fatal("expected error, but got none")
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "huge")); !os.IsNotExist(err) {
+			t.Fatalf("expected nothing to be created, got %v", err)
+		}
+	})
+}
+
 func TestWriteChunk(t *testing.T) {
 	t.Run("successful write", func(t *testing.T) {
 		f, err := os.CreateTemp("", "testfile-")
@@ -173,3 +234,30 @@ func TestWriteChunk(t *testing.T) {
 		}
 	})
 }
+
+func TestMove(t *testing.T) {
+	dirs := []string{t.TempDir()}
+	// /dev/shm is usually another filesystem, moving from it needs a copy
+	if shm, err := os.MkdirTemp("/dev/shm", "move-"); err == nil {
+		t.Cleanup(func() { os.RemoveAll(shm) })
+		dirs = append(dirs, shm)
+	}
+	dst := t.TempDir()
+
+	for i, dir := range dirs {
+		src := filepath.Join(dir, "src.txt")
+		if err := os.WriteFile(src, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		target := filepath.Join(dst, "nested", fmt.Sprintf("dst%d.txt", i))
+		if err := file.Move(src, target); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := os.Stat(src); !os.IsNotExist(err) {
+			t.Fatalf("expected source to be removed, got %v", err)
+		}
+		if data, err := os.ReadFile(target); err != nil || string(data) != "data" {
+			t.Fatalf("unexpected moved file: %q, %v", data, err)
+		}
+	}
+}
@@ -4,48 +4,70 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"time"
 
 	"github.com/username918r818/torrent-client/message"
 )
 
-func StartFileWorker(ctx context.Context, ch message.FileChannels) {
-	ch.ReadyChannel <- true
+func StartFileWorker(ctx context.Context, ch message.FileChannels, storage Storage) {
 	for {
 		select {
 		case msg := <-ch.ToSaveChannel:
-			if msg.Length == -1 {
-				time.Sleep(time.Second * 10)
-				ch.ReadyChannel <- true
-				break
-			}
 			slog.Info("File worker: received msg: " + fmt.Sprintf("%d", msg.Length))
-			data := make([]byte, msg.Length)
-			var index int64
-			pieceIndex := msg.Offset / msg.PieceLength
-			startPiece := msg.Offset % msg.PieceLength
-			copy(data, msg.Pieces[pieceIndex][startPiece:])
-			index = msg.PieceLength - startPiece
-
-			for index < msg.Length {
-				pieceIndex++
-				copy(data[index:], msg.Pieces[pieceIndex])
-				index += msg.PieceLength
+			key := Key{InfoHash: msg.InfoHash, Index: msg.FileIndex}
+			var err error
+			if d, ok := storage.(DirectWriter); ok && d.Direct(key) {
+				err = writePieces(storage, key, msg)
+			} else {
+				_, err = storage.WriteAt(key, joinPieces(msg), msg.FileOffset)
 			}
 
-			err := WriteChunk(msg.File, msg.FileOffset, data)
-
+			res := message.IsRangeSaved{IsSaved: true, Offset: msg.Offset, Length: msg.Length}
 			if err != nil {
 				slog.Error("File Worker: " + err.Error())
-				msg.Callback <- message.IsRangeSaved{}
-			} else {
-				msg.Callback <- message.IsRangeSaved{IsSaved: true, Offset: msg.Offset, Length: msg.Length}
+				res.IsSaved = false
+			}
+			// workers are shared by torrents, one of them may be stopped meanwhile
+			select {
+			case msg.Callback <- res:
+			case <-msg.Done:
+			case <-ctx.Done():
+				return
 			}
-
-			ch.ReadyChannel <- true
 		case <-ctx.Done():
 			return
 		}
 	}
 
 }
+
+// joinPieces copies data of msg from its pieces into a new buffer.
+func joinPieces(msg message.SaveRange) []byte {
+	data := make([]byte, msg.Length)
+	var index int64
+	pieceIndex := msg.Offset / msg.PieceLength
+	startPiece := msg.Offset % msg.PieceLength
+	copy(data, msg.Pieces[pieceIndex][startPiece:])
+	index = msg.PieceLength - startPiece
+
+	for index < msg.Length {
+		pieceIndex++
+		copy(data[index:], msg.Pieces[pieceIndex])
+		index += msg.PieceLength
+	}
+	return data
+}
+
+// writePieces writes data of msg piece by piece without copying it first.
+func writePieces(storage Storage, key Key, msg message.SaveRange) error {
+	for written := int64(0); written < msg.Length; {
+		offset := msg.Offset + written
+		piece := msg.Pieces[offset/msg.PieceLength]
+		start := offset % msg.PieceLength
+		chunk := piece[start:min(int64(len(piece)), start+msg.Length-written)]
+		if _, err := storage.WriteAt(key, chunk, msg.FileOffset+written); err != nil {
+			return err
+		}
+		written += int64(len(chunk))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package file_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/username918r818/torrent-client/file"
+	"github.com/username918r818/torrent-client/message"
+)
+
+func TestFileWorker(t *testing.T) {
+	key := file.Key{InfoHash: [20]byte{1}, Index: 0}
+	pieces := [][]byte{[]byte("0123"), []byte("4567"), []byte("89")}
+
+	for name, storage := range map[string]interface {
+		file.Storage
+		Add(file.Key, *os.File)
+	}{"disk": file.NewDisk(), "mmap": file.NewMmap()} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file.txt")
+			if err := os.WriteFile(path, make([]byte, 9), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			storage.Add(key, f)
+			defer storage.Close(key)
+
+			if d, ok := storage.(file.DirectWriter); ok && runtime.GOOS == "linux" && !d.Direct(key) {
+				t.Fatal("expected file to be mapped")
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			toSave, callback := make(chan message.SaveRange), make(chan message.IsRangeSaved)
+			go file.StartFileWorker(ctx, message.FileChannels{ToSaveChannel: toSave}, storage)
+
+			// bytes [1, 9) of the torrent go to the file from its offset 1
+			toSave <- message.SaveRange{InfoHash: key.InfoHash, Pieces: pieces, PieceLength: 4, Offset: 1, FileIndex: 0, FileOffset: 1, Length: 8, Callback: callback}
+			if res := <-callback; !res.IsSaved || res.Offset != 1 || res.Length != 8 {
+				t.Fatalf("unexpected result: %+v", res)
+			}
+
+			if err := storage.Sync(key); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data[1:]) != "12345678" {
+				t.Fatalf("unexpected file content: %q", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Package limit limits bandwidth with token buckets.
+package limit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket of bytes, it holds up to a second of traffic.
+// Takers reserve bytes in order they come and a taker that finds the bucket
+// empty waits for its bytes after those reserved before, so everyone sharing
+// the bucket gets bandwidth in turn. The rate may be changed at any time.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   int64   // bytes per second, no limit if zero
+	tokens float64 // negative when waiting takers reserved more than there is
+	last   time.Time
+}
+
+func NewBucket(rate int64) *Bucket {
+	return &Bucket{rate: max(rate, 0), tokens: float64(max(rate, 0)), last: time.Now()}
+}
+
+func (b *Bucket) Rate() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate
+}
+
+// SetRate changes the rate in bytes per second, zero means no limit.
+func (b *Bucket) SetRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.refill(now)
+	if b.rate == 0 {
+		b.tokens = float64(rate)
+	}
+	b.rate = max(rate, 0)
+	b.tokens = min(b.tokens, float64(b.rate))
+}
+
+// refill adds tokens for the time passed since the last call, mu has to be held.
+func (b *Bucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens = min(float64(b.rate), b.tokens+now.Sub(b.last).Seconds()*float64(b.rate))
+	}
+	b.last = now
+}
+
+// reserve takes n bytes and returns how long to wait until they are there.
+func (b *Bucket) reserve(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.rate == 0 {
+		return 0
+	}
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+}
+
+// Limiter applies several buckets at once, e.g. of a peer, its torrent and
+// the session. Nil buckets are skipped.
+type Limiter []*Bucket
+
+// Wait waits until n bytes may be transferred under all buckets.
+func (l Limiter) Wait(ctx context.Context, n int) error {
+	now := time.Now()
+	var wait time.Duration
+	for _, b := range l {
+		if b != nil {
+			wait = max(wait, b.reserve(n, now))
+		}
+	}
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Group makes buckets of the same rate, one for every peer for example.
+// Changing the rate of the group changes it for all its buckets.
+type Group struct {
+	mu      sync.Mutex
+	rate    int64
+	buckets map[*Bucket]struct{}
+}
+
+func NewGroup(rate int64) *Group {
+	return &Group{rate: rate, buckets: make(map[*Bucket]struct{})}
+}
+
+func (g *Group) Rate() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.rate
+}
+
+func (g *Group) SetRate(rate int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rate = rate
+	for b := range g.buckets {
+		b.SetRate(rate)
+	}
+}
+
+// New makes a bucket of the group, it has to be removed once it is not used.
+func (g *Group) New() *Bucket {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b := NewBucket(g.rate)
+	g.buckets[b] = struct{}{}
+	return b
+}
+
+func (g *Group) Remove(b *Bucket) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.buckets, b)
+}
@@ -0,0 +1,59 @@
+package limit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	t.Run("burst and wait", func(t *testing.T) {
+		b := NewBucket(1000)
+		now := b.last
+		if wait := b.reserve(1000, now); wait != 0 {
+			t.Fatalf("expected a second of traffic at once, got wait %v", wait)
+		}
+		if wait := b.reserve(500, now); wait != 500*time.Millisecond {
+			t.Fatalf("expected to wait 500ms, got %v", wait)
+		}
+		// the next taker waits after the previous one
+		if wait := b.reserve(500, now.Add(250*time.Millisecond)); wait != 750*time.Millisecond {
+			t.Fatalf("expected to wait 750ms, got %v", wait)
+		}
+	})
+
+	t.Run("rate change", func(t *testing.T) {
+		b := NewBucket(0)
+		if wait := b.reserve(1<<20, time.Now()); wait != 0 {
+			t.Fatalf("expected no limit, got wait %v", wait)
+		}
+		b.SetRate(100)
+		if wait := b.reserve(200, b.last); wait != time.Second {
+			t.Fatalf("expected to wait a second, got %v", wait)
+		}
+	})
+
+	t.Run("group", func(t *testing.T) {
+		g := NewGroup(100)
+		a, b := g.New(), g.New()
+		g.Remove(b)
+		g.SetRate(200)
+		if a.Rate() != 200 || b.Rate() != 100 {
+			t.Fatalf("expected rate of the group only for its buckets, got %d and %d", a.Rate(), b.Rate())
+		}
+	})
+
+	t.Run("limiter", func(t *testing.T) {
+		session, peer := NewBucket(0), NewBucket(10)
+		l := Limiter{session, nil, peer}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := l.Wait(ctx, 10); err != nil {
+			t.Fatalf("expected no wait, got %v", err)
+		}
+		// the slowest bucket wins
+		if err := l.Wait(ctx, 10); err != context.DeadlineExceeded {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+	})
+}
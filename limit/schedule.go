@@ -0,0 +1,127 @@
+package limit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Period is a time of day on some weekdays. A period that ends before it
+// starts goes over midnight, its days are days it starts on. A period that
+// ends when it starts lasts the whole day.
+type Period struct {
+	Days     [7]bool       // by time.Weekday
+	From, To time.Duration // since midnight
+}
+
+func (p Period) Active(t time.Time) bool {
+	since := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+	switch {
+	case p.From == p.To:
+		return p.Days[day]
+	case p.From < p.To:
+		return p.Days[day] && since >= p.From && since < p.To
+	}
+	return p.Days[day] && since >= p.From || p.Days[(day+6)%7] && since < p.To
+}
+
+// Schedule tells when alternative limits are on.
+type Schedule []Period
+
+func (s Schedule) Active(t time.Time) bool {
+	for _, p := range s {
+		if p.Active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseSchedule parses periods separated by semicolons, e.g.
+// "mon-fri 09:00-18:00; sat,sun 23:00-07:00". Days are given as ranges and
+// lists of three-letter names or "*" for every day.
+func ParseSchedule(s string) (Schedule, error) {
+	var schedule Schedule
+	for part := range strings.SplitSeq(s, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("schedule: want days and hours, got %q", strings.TrimSpace(part))
+		}
+		var p Period
+		if err := parseDays(fields[0], &p.Days); err != nil {
+			return nil, err
+		}
+		from, to, ok := strings.Cut(fields[1], "-")
+		if !ok {
+			return nil, fmt.Errorf("schedule: want hours as HH:MM-HH:MM, got %q", fields[1])
+		}
+		var err error
+		if p.From, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if p.To, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, p)
+	}
+	if len(schedule) == 0 {
+		return nil, errors.New("schedule: no periods")
+	}
+	return schedule, nil
+}
+
+func parseDays(s string, days *[7]bool) error {
+	if s == "*" {
+		for i := range days {
+			days[i] = true
+		}
+		return nil
+	}
+	day := func(name string) (int, error) {
+		for i, d := range weekdays {
+			if strings.EqualFold(name, d) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("schedule: unknown day %q", name)
+	}
+	for item := range strings.SplitSeq(s, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		from, err := day(first)
+		if err != nil {
+			return err
+		}
+		to := from
+		if isRange {
+			if to, err = day(last); err != nil {
+				return err
+			}
+		}
+		// ranges may wrap over the week, e.g. fri-mon
+		for i := from; ; i = (i + 1) % 7 {
+			days[i] = true
+			if i == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	var hours, minutes int
+	if n, err := fmt.Sscanf(s, "%d:%d", &hours, &minutes); err != nil || n != 2 || len(s) != 5 {
+		return 0, fmt.Errorf("schedule: want time as HH:MM, got %q", s)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || hours == 24 && minutes != 0 {
+		return 0, fmt.Errorf("schedule: wrong time %q", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
@@ -0,0 +1,66 @@
+package limit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/limit"
+)
+
+func TestSchedule(t *testing.T) {
+	// 2024-01-01 is a monday
+	at := func(day int, clock string) time.Time {
+		tm, err := time.Parse("15:04", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(2024, 1, day, tm.Hour(), tm.Minute(), 0, 0, time.Local)
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		for _, s := range []string{"", "mon", "mon 9:00-18:00", "mon 09:00", "xyz 09:00-18:00", "mon 25:00-26:00", "mon 09:00-18:60"} {
+			if _, err := limit.ParseSchedule(s); err == nil {
+				t.Errorf("expected error for %q", s)
+			}
+		}
+	})
+
+	t.Run("active", func(t *testing.T) {
+		s, err := limit.ParseSchedule("mon-fri 09:00-18:00; sat,sun 23:00-07:00; wed 00:00-00:00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cases := []struct {
+			t      time.Time
+			active bool
+		}{
+			{at(1, "08:59"), false},
+			{at(1, "09:00"), true},
+			{at(5, "17:59"), true},
+			{at(1, "18:00"), false},
+			{at(3, "20:00"), true},  // whole wednesday
+			{at(6, "23:30"), true},  // saturday night
+			{at(7, "06:59"), true},  // goes on sunday morning
+			{at(8, "06:00"), true},  // after sunday night
+			{at(8, "07:00"), false}, // monday morning
+			{at(6, "06:00"), false}, // friday night is not scheduled
+		}
+		for _, c := range cases {
+			if got := s.Active(c.t); got != c.active {
+				t.Errorf("%v: expected %v, got %v", c.t.Format("Mon 15:04"), c.active, got)
+			}
+		}
+	})
+
+	t.Run("wrapping days", func(t *testing.T) {
+		s, err := limit.ParseSchedule("fri-mon 10:00-11:00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for day, active := range map[int]bool{1: true, 2: false, 5: true, 6: true, 7: true} {
+			if got := s.Active(at(day, "10:30")); got != active {
+				t.Errorf("day %d: expected %v, got %v", day, active, got)
+			}
+		}
+	})
+}
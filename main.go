@@ -1,36 +1,117 @@
 package main
 
 import (
-	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/username918r818/torrent-client/torrent"
 )
 
+// Exit codes.
+const (
+	exitOK         = 0
+	exitError      = 1 // the command failed, e.g. a torrent failed or was not stopped cleanly
+	exitUsage      = 2 // wrong command line
+	exitTimeout    = 3 // torrents were not stopped within the shutdown timeout
+	exitIncomplete = 4 // verify found missing or corrupt data
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"download", "download and seed torrents", runDownload},
+	{"info", "print what a torrent file describes", runInfo},
+	{"create", "create a torrent file of a file or a directory", runCreate},
+	{"verify", "hash data of a torrent found on disk", runVerify},
+	{"magnet", "print magnet links of torrent files", runMagnet},
+}
+
 func main() {
-	argsWithoutProg := os.Args[1:]
-	if len(argsWithoutProg) != 1 {
-		fmt.Println("Need only one arg (torrent-file location)")
-		return
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return exitOK
 	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	errorf("unknown command %q", args[0])
+	usage(os.Stderr)
+	return exitUsage
+}
 
-	data, err := os.ReadFile(argsWithoutProg[0])
-	if err != nil {
-		fmt.Println("Can't read file:", err)
-		return
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: torrent-client <command> [flags] [arguments]\n\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
 	}
+	fmt.Fprintln(w, "\nRun torrent-client <command> -h for flags of a command.")
+}
+
+func errorf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "torrent-client: "+format+"\n", args...)
+}
 
-	torrentFile, err := torrent.New(data)
+// newFlagSet makes flags of a command that print its usage on errors.
+func newFlagSet(name, arguments, summary string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: torrent-client %s %s\n\n%s\n", name, arguments, summary)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(fs.Output(), "\nFlags:")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
 
+// parseFlags parses args of a command, it returns false with the exit code if
+// the command should not run.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	}
 	if err != nil {
-		fmt.Println("Can't build torrent structure:", err)
-		return
+		return exitUsage, false
 	}
+	return exitOK, true
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// usageError reports wrong arguments of a command.
+func usageError(fs *flag.FlagSet, format string, args ...any) int {
+	errorf(fs.Name()+": "+format, args...)
+	fmt.Fprintf(fs.Output(), "Run torrent-client %s -h for usage.\n", fs.Name())
+	return exitUsage
+}
 
-	go torrent.StartSupervisor(ctx, torrentFile, 1488)
-	select {}
+func readTorrent(path string) (torrent.TorrentFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return torrent.TorrentFile{}, err
+	}
+	tf, err := torrent.New(data)
+	if err != nil {
+		return tf, fmt.Errorf("%s: not a torrent file: %w", path, err)
+	}
+	return tf, nil
 }
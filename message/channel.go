@@ -4,6 +4,7 @@ type SupervisorChannels struct {
 	ToPeerWorkerToDownload map[[6]byte]chan<- DownloadRange // need initialize
 	FromPeerWorker         <-chan PeerMessage
 	GetPeers               <-chan Peers
+	Control                <-chan Command // need initialize with handle
 }
 
 type TrackerChannels struct {
@@ -20,14 +21,12 @@ type PeerChannels struct {
 type PieceChannels struct {
 	PostStatsChannel  chan<- StatDiff
 	PeerHasDownloaded <-chan Block
-	FileWorkerReady   <-chan Ready
 	FileWorkerIsSaved <-chan IsRangeSaved // need initialize with new torrent
 	CallBack          chan<- IsRangeSaved // need initialize with new torrent
 	FileWorkerToSave  chan<- SaveRange
 }
 
 type FileChannels struct {
-	ReadyChannel  chan<- Ready
 	ReportIsSaved map[[20]byte]chan<- IsRangeSaved
 	ToSaveChannel <-chan SaveRange
 }
@@ -55,9 +54,6 @@ func GetChannels() (SupervisorChannels, TrackerChannels, PeerChannels, PieceChan
 	peer.DownloadedChannel = downloadedChannel
 	piece.PeerHasDownloaded = downloadedChannel
 
-	fileWorkerReady := make(chan Ready)
-	piece.FileWorkerReady = fileWorkerReady
-	file.ReadyChannel = fileWorkerReady
 	file.ReportIsSaved = make(map[[20]byte]chan<- IsRangeSaved)
 	fileWorkerToSave := make(chan SaveRange)
 	piece.FileWorkerToSave = fileWorkerToSave
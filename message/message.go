@@ -1,16 +1,10 @@
 package message
 
-import (
-	"os"
-)
-
 type Block struct {
 	Offset int64
 	Length int64
 }
 
-type Ready = bool
-
 type IsRangeSaved struct {
 	IsSaved bool
 	Offset  int64
@@ -22,10 +16,11 @@ type SaveRange struct {
 	Pieces      [][]byte
 	PieceLength int64
 	Offset      int64
+	FileIndex   int // index of the file in the torrent, -1 for the part file
 	FileOffset  int64
 	Length      int64
-	File        *os.File
 	Callback    chan<- IsRangeSaved
+	Done        <-chan struct{} // closed when the torrent is stopped, the result is not reported then
 }
 
 type DownloadRange struct {
@@ -45,6 +40,11 @@ type PeerError struct {
 	Error error
 }
 
+type Command struct {
+	Id    int
+	Reply chan<- error // may be nil
+}
+
 type StatDiff = [6]int64
 
 type Peers = [][6]byte
@@ -0,0 +1,178 @@
+package stream
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/username918r818/torrent-client/torrent"
+)
+
+// Server serves files of running torrents over HTTP with Range requests, so
+// media players can play them while they download.
+//
+//	GET /                             list of torrents, their states and files
+//	GET /{info hash}/{index}/{name}   file with given index, name is optional
+//	POST /{info hash}/pause           pause the torrent
+//	POST /{info hash}/resume          resume the torrent
+//	POST /alt?mode={auto,on,off}      switch alternative limits of the session
+type Server struct {
+	mu      sync.Mutex
+	handles map[string]*torrent.Handle // by hex info hash
+	session *torrent.Session
+	mux     *http.ServeMux
+}
+
+func NewServer() *Server {
+	s := &Server{handles: make(map[string]*torrent.Handle), mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /{$}", s.serveIndex)
+	s.mux.HandleFunc("GET /{hash}/{index}/{name...}", s.serveFile)
+	s.mux.HandleFunc("POST /{hash}/pause", s.serveCommand((*torrent.Handle).Pause))
+	s.mux.HandleFunc("POST /{hash}/resume", s.serveCommand((*torrent.Handle).Resume))
+	s.mux.HandleFunc("POST /alt", s.serveAlt)
+	return s
+}
+
+// Add serves files of h until its supervisor returns.
+func (s *Server) Add(h *torrent.Handle) {
+	hash := hex.EncodeToString(h.File.InfoHash[:])
+	s.mu.Lock()
+	s.handles[hash] = h
+	s.mu.Unlock()
+
+	go func() {
+		<-h.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.handles[hash] == h {
+			delete(s.handles, hash)
+		}
+	}()
+}
+
+// SetSession sets the session whose limits are controlled through the server.
+func (s *Server) SetSession(session *torrent.Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session = session
+}
+
+func (s *Server) Remove(infoHash [20]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.handles, hex.EncodeToString(infoHash[:]))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintln(w, "<!DOCTYPE html>")
+	if s.session != nil {
+		fmt.Fprintf(w, "<p>Alternative limits: %s (%s), download %d B/s, upload %d B/s</p>\n",
+			altState(s.session), s.session.AltMode(), s.session.DownloadLimit(), s.session.UploadLimit())
+	}
+	fmt.Fprintln(w, "<ul>")
+	for hash, h := range s.handles {
+		fmt.Fprintf(w, "<li>%s (%s)<ul>\n", hash, h.State())
+		for i, f := range h.File.Files {
+			name := strings.Join(f.Path, "/")
+			link := fmt.Sprintf("/%s/%d/%s", hash, i, url.PathEscape(path.Base(name)))
+			fmt.Fprintf(w, "<li><a href=\"%s\">%s</a> (%d bytes)</li>\n", link, html.EscapeString(name), f.Length)
+		}
+		fmt.Fprintln(w, "</ul></li>")
+	}
+	fmt.Fprintln(w, "</ul>")
+}
+
+func (s *Server) handle(r *http.Request) (*torrent.Handle, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.handles[strings.ToLower(r.PathValue("hash"))]
+	return h, ok
+}
+
+// serveCommand runs cmd on the torrent and replies with its new state.
+func (s *Server) serveCommand(cmd func(*torrent.Handle, context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := s.handle(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if err := cmd(h, r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, h.State())
+	}
+}
+
+func altState(session *torrent.Session) string {
+	if session.AltActive() {
+		return "on"
+	}
+	return "off"
+}
+
+// serveAlt switches the mode of alternative limits and replies whether they
+// are used now.
+func (s *Server) serveAlt(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	session := s.session
+	s.mu.Unlock()
+	if session == nil {
+		http.NotFound(w, r)
+		return
+	}
+	mode, err := torrent.ParseAltMode(r.FormValue("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	session.SetAltMode(mode)
+	fmt.Fprintln(w, altState(session))
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	h, ok := s.handle(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 || index >= len(h.File.Files) {
+		http.NotFound(w, r)
+		return
+	}
+
+	reader, err := h.NewFileReader(r.Context(), index)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// content is not sniffed, it would block until the first bytes are downloaded
+	f := h.File.Files[index]
+	contentType := mime.TypeByExtension(path.Ext(f.Path[len(f.Path)-1]))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, f.Path[len(f.Path)-1], time.Time{}, reader)
+}
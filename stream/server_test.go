@@ -0,0 +1,170 @@
+package stream_test
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/stream"
+	"github.com/username918r818/torrent-client/torrent"
+	"github.com/username918r818/torrent-client/torrent/torrenttest"
+)
+
+func TestServer(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Chdir(tempDir)
+
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer tracker.Close()
+
+	tf := torrenttest.NewTorrent("root", "a.txt", "b.mp4")
+	tf.Announce = tracker.URL
+	tf.InfoHash[0] = 1
+
+	// data is already on disk, recheck on start marks it saved
+	if err := torrenttest.WriteFiles(&tf, torrenttest.Data[:6], torrenttest.Data[6:]); err != nil {
+		t.Fatal(err)
+	}
+
+	h := torrent.NewHandle(tf)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		torrent.StartSupervisor(ctx, h, 6881)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	server := stream.NewServer()
+	server.Add(h)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	hash := hex.EncodeToString(tf.InfoHash[:])
+
+	t.Run("whole file", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/" + hash + "/0/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != "012345" {
+			t.Fatalf("unexpected response: %v %q", resp.Status, body)
+		}
+		if resp.Header.Get("Content-Type") != "text/plain; charset=utf-8" || resp.ContentLength != 6 {
+			t.Fatalf("unexpected headers: %v", resp.Header)
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", ts.URL+"/"+hash+"/1/b.mp4", nil)
+		req.Header.Set("Range", "bytes=1-2")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusPartialContent || string(body) != "78" {
+			t.Fatalf("unexpected response: %v %q", resp.Status, body)
+		}
+		if resp.Header.Get("Content-Type") != "video/mp4" || resp.Header.Get("Content-Range") != "bytes 1-2/4" {
+			t.Fatalf("unexpected headers: %v", resp.Header)
+		}
+	})
+
+	t.Run("unknown file", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/" + hash + "/2/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected not found, got %v", resp.Status)
+		}
+	})
+	t.Run("pause", func(t *testing.T) {
+		for _, cmd := range []string{"pause", "resume"} {
+			resp, err := http.Post(ts.URL+"/"+hash+"/"+cmd, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			want := "paused\n"
+			if cmd == "resume" {
+				want = "seeding\n"
+			}
+			if resp.StatusCode != http.StatusOK || string(body) != want {
+				t.Fatalf("unexpected response to %s: %v %q", cmd, resp.Status, body)
+			}
+		}
+	})
+
+	t.Run("alternative limits", func(t *testing.T) {
+		resp, err := http.Post(ts.URL+"/alt?mode=on", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected not found without a session, got %v", resp.Status)
+		}
+
+		session := torrent.NewSession(ctx, 6881, torrent.DefaultConfig())
+		session.SetAltLimits(100, 10)
+		server.SetSession(session)
+		for _, c := range []struct{ mode, want string }{{"on", "on\n"}, {"off", "off\n"}, {"auto", "off\n"}} {
+			mode, want := c.mode, c.want
+			resp, err := http.Post(ts.URL+"/alt?mode="+mode, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(body) != want {
+				t.Fatalf("unexpected response to %s: %v %q", mode, resp.Status, body)
+			}
+		}
+		if session.AltMode() != torrent.AltAuto {
+			t.Fatalf("expected auto mode, got %v", session.AltMode())
+		}
+
+		resp, err = http.Post(ts.URL+"/alt?mode=fast", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected bad request, got %v", resp.Status)
+		}
+	})
+	t.Run("stopped torrent", func(t *testing.T) {
+		cancel()
+		<-done
+		deadline := time.Now().Add(time.Second)
+		for {
+			resp, err := http.Post(ts.URL+"/"+hash+"/pause", "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusNotFound {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected stopped torrent to be removed, got %v", resp.Status)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
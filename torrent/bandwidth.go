@@ -0,0 +1,258 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/username918r818/torrent-client/limit"
+)
+
+// bandwidth holds limits a peer worker is started with: buckets of the
+// session and the torrent shared by all peers, and groups the worker makes
+// buckets of its own from.
+type bandwidth struct {
+	down, up         limit.Limiter
+	peerDown, peerUp *limit.Group
+}
+
+// limitedConn is a peer connection under limits of the peer, its torrent
+// and the session. Every read of infiniteReadingMessage waits for its bytes
+// after they are read, every write of writeMessage before it is sent.
+type limitedConn struct {
+	net.Conn
+	ctx       context.Context
+	down, up  limit.Limiter
+	since     time.Time
+	throttled atomic.Int64 // nanoseconds reads waited for download limits
+	waiting   atomic.Int64 // UnixNano when the current wait started, 0 if none
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		start := time.Now()
+		c.waiting.Store(start.UnixNano())
+		if werr := c.down.Wait(c.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+		c.waiting.Store(0)
+		c.throttled.Add(int64(time.Since(start)))
+	}
+	return n, err
+}
+
+// active returns time since the connection was made without time its reads
+// waited for download limits, as if the peer sent blocks at our pace.
+func (c *limitedConn) active() time.Duration {
+	throttled := c.throttled.Load()
+	if w := c.waiting.Load(); w != 0 {
+		throttled += time.Now().UnixNano() - w
+	}
+	return time.Since(c.since) - time.Duration(throttled)
+}
+
+// peerClock returns the clock request and snub timers of conn run on, it
+// stops while reads wait for our own download limits.
+func peerClock(conn net.Conn) func() time.Duration {
+	if c, ok := conn.(*limitedConn); ok {
+		return c.active
+	}
+	start := time.Now()
+	return func() time.Duration { return time.Since(start) }
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	if err := c.up.Wait(c.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+// limit wraps conn into buckets of the peer made from groups of bw, release
+// has to be called once the connection is closed.
+func (bw bandwidth) limit(ctx context.Context, conn net.Conn) (c *limitedConn, release func()) {
+	down, up := bw.peerDown.New(), bw.peerUp.New()
+	c = &limitedConn{
+		Conn:  conn,
+		ctx:   ctx,
+		down:  append(limit.Limiter{down}, bw.down...),
+		up:    append(limit.Limiter{up}, bw.up...),
+		since: time.Now(),
+	}
+	return c, func() {
+		bw.peerDown.Remove(down)
+		bw.peerUp.Remove(up)
+	}
+}
+
+// AltMode selects when a session uses its alternative limits.
+type AltMode int
+
+const (
+	AltAuto AltMode = iota // by the schedule
+	AltOn
+	AltOff
+)
+
+func (m AltMode) String() string {
+	switch m {
+	case AltAuto:
+		return "auto"
+	case AltOn:
+		return "on"
+	case AltOff:
+		return "off"
+	}
+	return "unknown"
+}
+
+func ParseAltMode(s string) (AltMode, error) {
+	for _, m := range []AltMode{AltAuto, AltOn, AltOff} {
+		if s == m.String() {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown mode of alternative limits %q", s)
+}
+
+// speedLimits are normal and alternative limits of a session.
+type speedLimits struct {
+	down, up       int64
+	altDown, altUp int64
+	schedule       limit.Schedule
+	mode           AltMode
+	alt            bool // alternative limits are used now
+}
+
+// applyLimits sets rates of the buckets of the session to the limits used at
+// now, s.limitsMu has to be held.
+func (s *Session) applyLimits(now time.Time) {
+	l := &s.limits
+	switch l.mode {
+	case AltAuto:
+		l.alt = l.schedule.Active(now)
+	case AltOn:
+		l.alt = true
+	case AltOff:
+		l.alt = false
+	}
+	if l.alt {
+		s.down.SetRate(l.altDown)
+		s.up.SetRate(l.altUp)
+	} else {
+		s.down.SetRate(l.down)
+		s.up.SetRate(l.up)
+	}
+}
+
+// runSchedule switches limits by the schedule at the start of every minute.
+func (s *Session) runSchedule(ctx context.Context) {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-timer.C:
+			s.limitsMu.Lock()
+			s.applyLimits(time.Now())
+			s.limitsMu.Unlock()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (s *Session) setLimits(set func(l *speedLimits)) {
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	set(&s.limits)
+	s.applyLimits(time.Now())
+}
+
+// SetDownloadLimit limits download of all torrents of the session together
+// in bytes per second, zero means no limit.
+func (s *Session) SetDownloadLimit(rate int64) {
+	s.setLimits(func(l *speedLimits) { l.down = rate })
+}
+
+// SetUploadLimit limits upload of all torrents of the session together in
+// bytes per second, zero means no limit.
+func (s *Session) SetUploadLimit(rate int64) {
+	s.setLimits(func(l *speedLimits) { l.up = rate })
+}
+
+// SetAltLimits sets limits used instead of normal ones while alternative
+// limits are on, zero means no limit.
+func (s *Session) SetAltLimits(down, up int64) {
+	s.setLimits(func(l *speedLimits) { l.altDown, l.altUp = down, up })
+}
+
+// SetAltSchedule sets when alternative limits are on in AltAuto mode, they
+// are never on without a schedule.
+func (s *Session) SetAltSchedule(schedule limit.Schedule) {
+	s.setLimits(func(l *speedLimits) { l.schedule = schedule })
+}
+
+// SetAltMode turns alternative limits on or off regardless of the schedule,
+// AltAuto goes back to the schedule.
+func (s *Session) SetAltMode(m AltMode) {
+	s.setLimits(func(l *speedLimits) { l.mode = m })
+}
+
+func (s *Session) AltMode() AltMode {
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	return s.limits.mode
+}
+
+// AltActive reports whether alternative limits are used now.
+func (s *Session) AltActive() bool {
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	return s.limits.alt
+}
+
+// DownloadLimit returns the download limit of the session used now.
+func (s *Session) DownloadLimit() int64 {
+	return s.down.Rate()
+}
+
+// UploadLimit returns the upload limit of the session used now.
+func (s *Session) UploadLimit() int64 {
+	return s.up.Rate()
+}
+
+// SetDownloadLimit limits download of the torrent in bytes per second, zero
+// means no limit. It may be changed while the torrent is running.
+func (h *Handle) SetDownloadLimit(rate int64) {
+	h.down.SetRate(rate)
+}
+
+// SetUploadLimit limits upload of the torrent in bytes per second, zero means
+// no limit. It may be changed while the torrent is running.
+func (h *Handle) SetUploadLimit(rate int64) {
+	h.up.SetRate(rate)
+}
+
+// SetPeerDownloadLimit limits download from every peer of the torrent in
+// bytes per second, zero means no limit.
+func (h *Handle) SetPeerDownloadLimit(rate int64) {
+	h.peerDown.SetRate(rate)
+}
+
+// SetPeerUploadLimit limits upload to every peer of the torrent in bytes per
+// second, zero means no limit.
+func (h *Handle) SetPeerUploadLimit(rate int64) {
+	h.peerUp.SetRate(rate)
+}
+
+func (h *Handle) DownloadLimit() int64 {
+	return h.down.Rate()
+}
+
+func (h *Handle) UploadLimit() int64 {
+	return h.up.Rate()
+}
@@ -0,0 +1,126 @@
+package torrent
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/limit"
+)
+
+func TestBandwidth(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("shared download", func(t *testing.T) {
+		session := limit.NewBucket(10000)
+		bw := bandwidth{down: limit.Limiter{session}, peerDown: limit.NewGroup(0), peerUp: limit.NewGroup(0)}
+
+		// two peers share a second of traffic at once, the rest waits
+		start := time.Now()
+		done := make(chan error, 2)
+		for range 2 {
+			local, remote := net.Pipe()
+			defer local.Close()
+			defer remote.Close()
+			conn, release := bw.limit(ctx, local)
+			defer release()
+			go remote.Write(make([]byte, 7500))
+			go func() {
+				_, err := io.ReadFull(conn, make([]byte, 7500))
+				done <- err
+			}()
+		}
+		for range 2 {
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+			t.Fatalf("expected to wait about 500ms, got %v", elapsed)
+		}
+	})
+
+	t.Run("peer upload changed at runtime", func(t *testing.T) {
+		bw := bandwidth{peerDown: limit.NewGroup(0), peerUp: limit.NewGroup(0)}
+		local, remote := net.Pipe()
+		defer local.Close()
+		defer remote.Close()
+		conn, release := bw.limit(ctx, local)
+		defer release()
+		go io.Copy(io.Discard, remote)
+
+		bw.peerUp.SetRate(100)
+		waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		conn.ctx = waitCtx
+		if _, err := conn.Write(make([]byte, 200)); err != context.DeadlineExceeded {
+			t.Fatalf("expected write to wait for the peer limit, got %v", err)
+		}
+	})
+
+	t.Run("peer clock", func(t *testing.T) {
+		bw := bandwidth{down: limit.Limiter{limit.NewBucket(1000)}, peerDown: limit.NewGroup(0), peerUp: limit.NewGroup(0)}
+		local, remote := net.Pipe()
+		defer local.Close()
+		defer remote.Close()
+		conn, release := bw.limit(ctx, local)
+		defer release()
+		clock := peerClock(conn)
+		go remote.Write(make([]byte, 1500))
+
+		// request timers don't run while a read waits for our own limit
+		start := time.Now()
+		if _, err := io.ReadFull(conn, make([]byte, 1500)); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed, active := time.Since(start), clock(); elapsed < 400*time.Millisecond || active > 100*time.Millisecond {
+			t.Fatalf("expected clock to stop while throttled, got %v of %v", active, elapsed)
+		}
+	})
+}
+
+func TestAltLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewSession(ctx, 6881, DefaultConfig())
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+
+	schedule, err := limit.ParseSchedule("* 09:00-18:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetDownloadLimit(1000)
+	s.SetAltLimits(100, 10)
+	s.SetAltSchedule(schedule)
+
+	check := func(alt bool, down, up int64) {
+		t.Helper()
+		if s.AltActive() != alt || s.DownloadLimit() != down || s.UploadLimit() != up {
+			t.Fatalf("expected alt %v with %d/%d, got %v with %d/%d", alt, down, up, s.AltActive(), s.DownloadLimit(), s.UploadLimit())
+		}
+	}
+
+	day := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	night := time.Date(2024, 1, 1, 20, 0, 0, 0, time.Local)
+	s.limitsMu.Lock()
+	s.applyLimits(day)
+	s.limitsMu.Unlock()
+	check(true, 100, 10)
+	s.limitsMu.Lock()
+	s.applyLimits(night)
+	s.limitsMu.Unlock()
+	check(false, 1000, 0)
+
+	// manual override wins over the schedule
+	s.SetAltMode(AltOn)
+	check(true, 100, 10)
+	s.SetAltMode(AltOff)
+	s.limitsMu.Lock()
+	s.applyLimits(day)
+	s.limitsMu.Unlock()
+	check(false, 1000, 0)
+}
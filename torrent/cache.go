@@ -0,0 +1,125 @@
+package torrent
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/username918r818/torrent-client/file"
+)
+
+// CacheStats are counters of a ReadCache.
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	Used   int64 // bytes of cached pieces
+	Size   int64
+}
+
+type cacheKey struct {
+	infoHash [20]byte
+	index    int
+}
+
+type cachedPiece struct {
+	key  cacheKey
+	data []byte
+}
+
+// ReadCache keeps recently read pieces in memory, least recently used pieces
+// are dropped once it is full. A block is read by reading its whole piece, so
+// next blocks of the piece are already cached. It may be shared by torrents.
+type ReadCache struct {
+	mu     sync.Mutex // guards fields below
+	size   int64
+	used   int64
+	order  *list.List // of *cachedPiece, most recently used first
+	pieces map[cacheKey]*list.Element
+	hits   int64
+	misses int64
+}
+
+// NewReadCache makes a cache of up to size bytes, zero size disables caching.
+func NewReadCache(size int64) *ReadCache {
+	return &ReadCache{size: size, order: list.New(), pieces: make(map[cacheKey]*list.Element)}
+}
+
+func (c *ReadCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Used: c.used, Size: c.size}
+}
+
+// SetSize changes the size of the cache, pieces above it are dropped.
+func (c *ReadCache) SetSize(size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	c.shrink()
+}
+
+// shrink drops least recently used pieces until they fit, mu has to be held.
+func (c *ReadCache) shrink() {
+	for c.used > c.size {
+		e := c.order.Back()
+		p := e.Value.(*cachedPiece)
+		c.order.Remove(e)
+		delete(c.pieces, p.key)
+		c.used -= int64(len(p.data))
+	}
+}
+
+func (c *ReadCache) get(key cacheKey) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.pieces[key]
+	if !ok {
+		c.misses++
+		return nil
+	}
+	c.hits++
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedPiece).data
+}
+
+func (c *ReadCache) put(key cacheKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pieces[key]; ok || int64(len(data)) > c.size {
+		return
+	}
+	c.pieces[key] = c.order.PushFront(&cachedPiece{key, data})
+	c.used += int64(len(data))
+	c.shrink()
+}
+
+// ReadBlock reads len(buf) bytes of saved piece index starting from begin.
+// Pieces are checked against their hashes before they are cached, so cached
+// data stays valid even if files are changed later. Pieces that are not saved
+// completely yet are read directly.
+func (c *ReadCache) ReadBlock(tf *TorrentFile, a *PieceArray, storage file.Storage, index int, begin int64, buf []byte) error {
+	lw, up := a.pieceBounds(index)
+	if begin < 0 || lw+begin+int64(len(buf)) > up {
+		return fmt.Errorf("cache: block is out of piece %d", index)
+	}
+
+	key := cacheKey{tf.InfoHash, index}
+	if data := c.get(key); data != nil {
+		copy(buf, data[begin:])
+		return nil
+	}
+	if !a.IsSaved(lw, up) {
+		return readRange(tf, a, storage, lw+begin, buf)
+	}
+
+	data := make([]byte, up-lw)
+	if err := readRange(tf, a, storage, lw, data); err != nil {
+		return err
+	}
+	if !Validate(data, tf.Pieces[index]) {
+		return fmt.Errorf("cache: piece %d on disk does not match its hash", index)
+	}
+	c.put(key, data)
+	copy(buf, data[begin:])
+	return nil
+}
@@ -0,0 +1,69 @@
+package torrent_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/username918r818/torrent-client/torrent"
+	"github.com/username918r818/torrent-client/torrent/torrenttest"
+)
+
+func TestReadCache(t *testing.T) {
+	tempDir := t.TempDir()
+	tf := torrenttest.NewTorrent(tempDir, "a.txt", "b.txt")
+	if err := torrenttest.WriteFiles(&tf, torrenttest.Data[:6], torrenttest.Data[6:]); err != nil {
+		t.Fatal(err)
+	}
+	storage, err := torrenttest.OpenFiles(&tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := torrent.InitPieceArray(int64(len(torrenttest.Data)), tf.PieceLength)
+	if _, err := torrent.Recheck(context.Background(), &tf, &a, storage, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// two full pieces fit
+	c := torrent.NewReadCache(8)
+	read := func(t *testing.T, index int, begin int64, want string) {
+		t.Helper()
+		buf := make([]byte, len(want))
+		if err := c.ReadBlock(&tf, &a, storage, index, begin, buf); err != nil || string(buf) != want {
+			t.Fatalf("expected %q, got %q, %v", want, buf, err)
+		}
+	}
+
+	t.Run("read ahead", func(t *testing.T) {
+		read(t, 0, 1, "12")
+		read(t, 0, 0, "0123")
+		// the second piece spans both files
+		read(t, 1, 0, "4567")
+		if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 2 || stats.Used != 8 {
+			t.Fatalf("unexpected stats: %+v", stats)
+		}
+	})
+
+	t.Run("least recently used", func(t *testing.T) {
+		read(t, 2, 0, "89")
+		read(t, 1, 2, "67")
+		read(t, 0, 0, "0123")
+		read(t, 1, 0, "4567")
+		if stats := c.Stats(); stats.Hits != 3 || stats.Misses != 4 || stats.Used != 8 {
+			t.Fatalf("expected the last piece to be dropped, got %+v", stats)
+		}
+	})
+
+	t.Run("wrong data", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(tempDir, "b.txt"), []byte("XXXX"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c.SetSize(4)
+		if err := c.ReadBlock(&tf, &a, storage, 2, 0, make([]byte, 2)); err == nil {
+			t.Fatal("expected piece with wrong data not to be read")
+		}
+		// cached data was checked before
+		read(t, 1, 0, "4567")
+	})
+}
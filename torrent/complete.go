@@ -0,0 +1,42 @@
+package torrent
+
+// completion follows wanted files of a torrent until all their data is saved.
+type completion struct {
+	a      *PieceArray
+	starts []int64 // offsets of files in the torrent
+	ends   []int64
+	left   []int // indexes of wanted files that are not complete yet
+}
+
+func newCompletion(tf *TorrentFile, a *PieceArray, priorities []FilePriority) *completion {
+	c := &completion{a: a}
+	var fileStart int64
+	for i, f := range tf.Files {
+		c.starts = append(c.starts, fileStart)
+		c.ends = append(c.ends, fileStart+f.Length)
+		if priorities[i] != PrioritySkip && f.Length > 0 {
+			c.left = append(c.left, i)
+		}
+		fileStart += f.Length
+	}
+	return c
+}
+
+// done returns files completed since the last call.
+func (c *completion) done() []int {
+	var done []int
+	left := c.left[:0]
+	for _, i := range c.left {
+		if c.a.IsSaved(c.starts[i], c.ends[i]) {
+			done = append(done, i)
+		} else {
+			left = append(left, i)
+		}
+	}
+	c.left = left
+	return done
+}
+
+func (c *completion) complete() bool {
+	return len(c.left) == 0
+}
@@ -0,0 +1,160 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Config holds settings of a session and of workers of its torrents.
+type Config struct {
+	Peers           int           // peer connections of a torrent
+	FileWorkers     int           // of a session, shared by its torrents
+	PieceWorkers    int           // of a torrent
+	ReconnectDelay  time.Duration // before a dropped peer is connected again
+	PeerTimeout     time.Duration // to read or write a peer message
+	InterestedDelay time.Duration // between the bitfield of a peer and our interested message
+	RequestTimeout  time.Duration // a request without answer is cancelled and sent again
+	SnubTimeout     time.Duration // an unchoking peer without any block for this long is snubbed
+	SnubBackoff     time.Duration // a snubbed peer gets a task again after this, doubled up to 4 times on each snub
+	FlushSize       int64         // validated data is written in chunks of up to this many bytes
+	FlushAge        time.Duration // smaller chunks are written once the oldest of them is this old
+	ShutdownTimeout time.Duration // a stopped torrent gives up writing data after it
+	QueueInterval   time.Duration // how often the queue of a session is checked
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Peers:           20,
+		FileWorkers:     2,
+		PieceWorkers:    20,
+		ReconnectDelay:  20 * time.Second,
+		PeerTimeout:     3 * time.Minute,
+		InterestedDelay: 5 * time.Second,
+		RequestTimeout:  30 * time.Second,
+		SnubTimeout:     60 * time.Second,
+		SnubBackoff:     30 * time.Second,
+		FlushSize:       4 << 20,
+		FlushAge:        2 * time.Second,
+		ShutdownTimeout: 30 * time.Second,
+		QueueInterval:   time.Second,
+	}
+}
+
+// configFile is Config as it is written in JSON, durations are strings like "20s".
+type configFile struct {
+	Peers           *int    `json:"peers"`
+	FileWorkers     *int    `json:"file_workers"`
+	PieceWorkers    *int    `json:"piece_workers"`
+	ReconnectDelay  *string `json:"reconnect_delay"`
+	PeerTimeout     *string `json:"peer_timeout"`
+	InterestedDelay *string `json:"interested_delay"`
+	RequestTimeout  *string `json:"request_timeout"`
+	SnubTimeout     *string `json:"snub_timeout"`
+	SnubBackoff     *string `json:"snub_backoff"`
+	FlushSize       *int64  `json:"flush_size"`
+	FlushAge        *string `json:"flush_age"`
+	ShutdownTimeout *string `json:"shutdown_timeout"`
+	QueueInterval   *string `json:"queue_interval"`
+}
+
+// LoadConfig reads a JSON file over cfg, settings missing in the file are
+// left as they are.
+func LoadConfig(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	var f configFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	for _, v := range []struct {
+		from *int
+		to   *int
+	}{{f.Peers, &cfg.Peers}, {f.FileWorkers, &cfg.FileWorkers}, {f.PieceWorkers, &cfg.PieceWorkers}} {
+		if v.from != nil {
+			*v.to = *v.from
+		}
+	}
+	if f.FlushSize != nil {
+		cfg.FlushSize = *f.FlushSize
+	}
+	for _, v := range []struct {
+		name string
+		from *string
+		to   *time.Duration
+	}{
+		{"reconnect_delay", f.ReconnectDelay, &cfg.ReconnectDelay},
+		{"peer_timeout", f.PeerTimeout, &cfg.PeerTimeout},
+		{"interested_delay", f.InterestedDelay, &cfg.InterestedDelay},
+		{"request_timeout", f.RequestTimeout, &cfg.RequestTimeout},
+		{"snub_timeout", f.SnubTimeout, &cfg.SnubTimeout},
+		{"snub_backoff", f.SnubBackoff, &cfg.SnubBackoff},
+		{"flush_age", f.FlushAge, &cfg.FlushAge},
+		{"shutdown_timeout", f.ShutdownTimeout, &cfg.ShutdownTimeout},
+		{"queue_interval", f.QueueInterval, &cfg.QueueInterval},
+	} {
+		if v.from == nil {
+			continue
+		}
+		d, err := time.ParseDuration(*v.from)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", v.name, err)
+		}
+		*v.to = d
+	}
+	return nil
+}
+
+// Validate checks that every worker has something to work with and every
+// timer runs.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Peers < 1 {
+		errs = append(errs, errors.New("config: a torrent needs at least one peer"))
+	}
+	if c.FileWorkers < 1 {
+		errs = append(errs, errors.New("config: a session needs at least one file worker"))
+	}
+	if c.PieceWorkers < 1 {
+		errs = append(errs, errors.New("config: a torrent needs at least one piece worker"))
+	}
+	if c.ReconnectDelay < 0 {
+		errs = append(errs, errors.New("config: reconnect delay is negative"))
+	}
+	if c.PeerTimeout <= 0 {
+		errs = append(errs, errors.New("config: peer timeout has to be positive"))
+	}
+	if c.InterestedDelay < 0 {
+		errs = append(errs, errors.New("config: interested delay is negative"))
+	}
+	if c.RequestTimeout <= 0 {
+		errs = append(errs, errors.New("config: request timeout has to be positive"))
+	}
+	if c.SnubTimeout <= 0 {
+		errs = append(errs, errors.New("config: snub timeout has to be positive"))
+	}
+	if c.SnubBackoff < 0 {
+		errs = append(errs, errors.New("config: snub backoff is negative"))
+	}
+	if c.FlushSize < BlockSize {
+		errs = append(errs, fmt.Errorf("config: flush size has to be at least a block of %d bytes", BlockSize))
+	}
+	if c.FlushAge <= 0 {
+		errs = append(errs, errors.New("config: flush age has to be positive"))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("config: shutdown timeout has to be positive"))
+	}
+	if c.QueueInterval <= 0 {
+		errs = append(errs, errors.New("config: queue interval has to be positive"))
+	}
+	return errors.Join(errs...)
+}
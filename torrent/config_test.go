@@ -0,0 +1,65 @@
+package torrent_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/torrent"
+)
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("load", func(t *testing.T) {
+		cfg := torrent.DefaultConfig()
+		path := write("ok.json", `{"peers": 50, "reconnect_delay": "1m30s", "flush_size": 1048576, "shutdown_timeout": "1m"}`)
+		if err := torrent.LoadConfig(path, &cfg); err != nil {
+			t.Fatal(err)
+		}
+		want := torrent.DefaultConfig()
+		want.Peers, want.ReconnectDelay = 50, 90*time.Second
+		want.FlushSize, want.ShutdownTimeout = 1<<20, time.Minute
+		if cfg != want {
+			t.Fatalf("expected %+v, got %+v", want, cfg)
+		}
+	})
+
+	t.Run("wrong file", func(t *testing.T) {
+		for name, data := range map[string]string{
+			"unknown.json":  `{"peer": 50}`,
+			"duration.json": `{"peer_timeout": "3"}`,
+			"size.json":     `{"flush_size": "4MiB"}`,
+			"syntax.json":   `{"peers": }`,
+		} {
+			cfg := torrent.DefaultConfig()
+			if err := torrent.LoadConfig(write(name, data), &cfg); err == nil {
+				t.Errorf("%s: expected error, but got none", name)
+			}
+		}
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		if err := torrent.DefaultConfig().Validate(); err != nil {
+			t.Fatalf("expected default config to be valid, got %v", err)
+		}
+		cfg := torrent.DefaultConfig()
+		cfg.FileWorkers, cfg.PeerTimeout = 0, 0
+		if err := cfg.Validate(); err == nil {
+			t.Fatal("expected error, but got none")
+		}
+		cfg = torrent.DefaultConfig()
+		cfg.FlushSize, cfg.QueueInterval = 100, 0
+		if err := cfg.Validate(); err == nil {
+			t.Fatal("expected error for small flushes and no queue interval, but got none")
+		}
+	})
+}
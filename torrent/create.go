@@ -0,0 +1,215 @@
+package torrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/username918r818/torrent-client/file"
+	"github.com/username918r818/torrent-client/util"
+)
+
+// CreateOptions are settings of a created torrent.
+type CreateOptions struct {
+	Trackers    []string // the first one is the announce, each is a tier of the announce list
+	PieceLength int64    // picked by the size if zero
+	Private     bool
+	Comment     string
+}
+
+// PieceLengthFor picks a piece length giving about 1500 pieces, a power of
+// two between 16 KiB and 16 MiB.
+func PieceLengthFor(total int64) int64 {
+	length := int64(16 << 10)
+	for length < 16<<20 && total/length > 1500 {
+		length *= 2
+	}
+	return length
+}
+
+// Create makes a metainfo file of a file or a directory. Files of a directory
+// are added in order of their paths, empty directories are left out.
+func Create(root string, opts CreateOptions) ([]byte, error) {
+	if len(opts.Trackers) == 0 {
+		return nil, errors.New("create: no trackers")
+	}
+	root = filepath.Clean(root)
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("create: %w", err)
+	}
+
+	type entry struct {
+		path   string   // on disk
+		parts  []string // in the torrent, under the name
+		length int64
+	}
+	var files []entry
+	if info.IsDir() {
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, entry{path, strings.Split(filepath.ToSlash(rel), "/"), fi.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create: %w", err)
+		}
+		// WalkDir goes in lexical order of names, paths are compared as a whole
+		slices.SortFunc(files, func(a, b entry) int { return slices.Compare(a.parts, b.parts) })
+	} else {
+		files = append(files, entry{root, nil, info.Size()})
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.length
+	}
+	if total == 0 {
+		return nil, errors.New("create: nothing to share")
+	}
+	pieceLength := opts.PieceLength
+	if pieceLength <= 0 {
+		pieceLength = PieceLengthFor(total)
+	}
+
+	// data of all files goes through pieces as one stream
+	var pieces []byte
+	buf := make([]byte, 0, pieceLength)
+	for _, f := range files {
+		r, err := os.Open(f.path)
+		if err != nil {
+			return nil, fmt.Errorf("create: %w", err)
+		}
+		for {
+			n, err := io.ReadFull(r, buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+			if len(buf) == cap(buf) {
+				sum := sha1.Sum(buf)
+				pieces = append(pieces, sum[:]...)
+				buf = buf[:0]
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				r.Close()
+				return nil, fmt.Errorf("create: %w", err)
+			}
+		}
+		r.Close()
+	}
+	if len(buf) > 0 {
+		sum := sha1.Sum(buf)
+		pieces = append(pieces, sum[:]...)
+	}
+
+	str := func(s string) util.Be { return util.Be{Tag: util.BeStr, Str: []byte(s)} }
+	integer := func(n int64) util.Be { return util.Be{Tag: util.BeInt, Int: n} }
+	infoDict := map[string]util.Be{
+		"name":         str(filepath.Base(root)),
+		"piece length": integer(pieceLength),
+		"pieces":       {Tag: util.BeStr, Str: pieces},
+	}
+	if info.IsDir() {
+		list := make([]util.Be, len(files))
+		for i, f := range files {
+			path := make([]util.Be, len(f.parts))
+			for j, p := range f.parts {
+				path[j] = str(p)
+			}
+			list[i] = util.Be{Tag: util.BeDict, Dict: &map[string]util.Be{
+				"length": integer(f.length),
+				"path":   {Tag: util.BeList, List: path},
+			}}
+		}
+		infoDict["files"] = util.Be{Tag: util.BeList, List: list}
+	} else {
+		infoDict["length"] = integer(total)
+	}
+	if opts.Private {
+		infoDict["private"] = integer(1)
+	}
+
+	dict := map[string]util.Be{
+		"announce": str(opts.Trackers[0]),
+		"info":     {Tag: util.BeDict, Dict: &infoDict},
+	}
+	if len(opts.Trackers) > 1 {
+		tiers := make([]util.Be, len(opts.Trackers))
+		for i, tracker := range opts.Trackers {
+			tiers[i] = util.Be{Tag: util.BeList, List: []util.Be{str(tracker)}}
+		}
+		dict["announce-list"] = util.Be{Tag: util.BeList, List: tiers}
+	}
+	if opts.Comment != "" {
+		dict["comment"] = str(opts.Comment)
+	}
+	return util.Encode(&util.Be{Tag: util.BeDict, Dict: &dict}), nil
+}
+
+// VerifyResult tells how much of a torrent was found valid on disk.
+type VerifyResult struct {
+	Valid, Total int   // pieces
+	Incomplete   []int // indexes of files with missing or corrupt data
+}
+
+// Verify hashes data of tf found in dir. Files are looked up by their names
+// and then with IncompleteSuffix.
+func Verify(ctx context.Context, tf *TorrentFile, dir string, workers int, progress func(RecheckProgress)) (VerifyResult, error) {
+	placed := *tf
+	placed.Files = slices.Clone(tf.Files)
+	for i, f := range placed.Files {
+		placed.Files[i].Path = append([]string{dir}, f.Path...)
+	}
+	files := newLayout(&placed, true, "")
+	onDisk := &files.files
+
+	storage := file.NewDisk()
+	for i, f := range onDisk.Files {
+		if f.Length > 0 && exists(f.Path) {
+			storage.AddPath(file.Key{InfoHash: tf.InfoHash, Index: i}, filepath.Join(f.Path...))
+		}
+	}
+	defer func() {
+		for i := range onDisk.Files {
+			storage.Close(file.Key{InfoHash: tf.InfoHash, Index: i})
+		}
+	}()
+
+	pieces := InitPieceArray(tf.Length(), tf.PieceLength)
+	if _, err := Recheck(ctx, onDisk, &pieces, storage, workers, progress); err != nil {
+		return VerifyResult{}, err
+	}
+
+	res := VerifyResult{Total: len(pieces.pieces)}
+	for _, p := range pieces.pieces {
+		if p.state == Saved {
+			res.Valid++
+		}
+	}
+	var offset int64
+	for i, f := range tf.Files {
+		if f.Length > 0 && !pieces.IsSaved(offset, offset+f.Length) {
+			res.Incomplete = append(res.Incomplete, i)
+		}
+		offset += f.Length
+	}
+	return res, nil
+}
@@ -0,0 +1,92 @@
+package torrent_test
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/username918r818/torrent-client/torrent"
+)
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	files := map[string]string{
+		"b.txt":     strings.Repeat("b", 40),
+		"a/c.txt":   strings.Repeat("c", 25),
+		"a.txt":     "",
+		"a/d/e.txt": strings.Repeat("e", 7),
+	}
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := torrent.Create(root, torrent.CreateOptions{Trackers: []string{"http://a/announce", "http://b/announce"}, PieceLength: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := torrent.New(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("metainfo", func(t *testing.T) {
+		var paths []string
+		for _, f := range tf.Files {
+			paths = append(paths, strings.Join(f.Path, "/"))
+		}
+		want := []string{"root/a/c.txt", "root/a/d/e.txt", "root/a.txt", "root/b.txt"}
+		if !slices.Equal(paths, want) {
+			t.Fatalf("expected files %v, got %v", want, paths)
+		}
+		if tf.Announce != "http://a/announce" || !slices.Equal(tf.ReserveAnnounce, []string{"http://a/announce", "http://b/announce"}) {
+			t.Fatalf("unexpected trackers %q %v", tf.Announce, tf.ReserveAnnounce)
+		}
+		if tf.Length() != 72 || len(tf.Pieces) != 5 {
+			t.Fatalf("expected 72 bytes in 5 pieces, got %d in %d", tf.Length(), len(tf.Pieces))
+		}
+	})
+
+	t.Run("magnet", func(t *testing.T) {
+		want := "magnet:?xt=urn:btih:" + hex.EncodeToString(tf.InfoHash[:]) + "&dn=root&xl=72&tr=http%3A%2F%2Fa%2Fannounce&tr=http%3A%2F%2Fb%2Fannounce"
+		if got := tf.Magnet(); got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		res, err := torrent.Verify(context.Background(), &tf, dir, 2, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Valid != 5 || res.Total != 5 || len(res.Incomplete) != 0 {
+			t.Fatalf("expected all pieces valid, got %+v", res)
+		}
+
+		// an incomplete file is found by its suffix, a missing one fails its pieces
+		c := filepath.Join(root, "a", "c.txt")
+		if err := os.Rename(c, c+torrent.IncompleteSuffix); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(filepath.Join(root, "b.txt")); err != nil {
+			t.Fatal(err)
+		}
+		res, err = torrent.Verify(context.Background(), &tf, dir, 2, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Valid != 2 || !slices.Equal(res.Incomplete, []int{3}) {
+			t.Fatalf("expected 2 valid pieces and only b.txt incomplete, got %+v", res)
+		}
+	})
+}
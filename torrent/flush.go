@@ -0,0 +1,158 @@
+package torrent
+
+import (
+	"context"
+	"time"
+
+	"github.com/username918r818/torrent-client/message"
+	"github.com/username918r818/torrent-client/util"
+)
+
+// addToSave adds validated data to the write cache and wakes up the flusher.
+func (a *PieceArray) addToSave(from, to int64) {
+	a.listTLock.Lock()
+	if a.toSave == nil {
+		a.toSaveSince = time.Now()
+	}
+	a.toSave = util.InsertRange(a.toSave, from, to)
+	a.listTLock.Unlock()
+
+	select {
+	case a.toSaveCh <- struct{}{}:
+	default:
+	}
+}
+
+// flushAll makes the flusher write everything it holds right away.
+func (a *PieceArray) flushAll() {
+	a.listTLock.Lock()
+	if a.toSave != nil {
+		a.toSaveSince = time.Time{}
+	}
+	a.listTLock.Unlock()
+
+	select {
+	case a.toSaveCh <- struct{}{}:
+	default:
+	}
+}
+
+// flushed tells whether all validated data is written to storage.
+func (a *PieceArray) flushed() bool {
+	a.listTLock.Lock()
+	defer a.listTLock.Unlock()
+	return a.toSave == nil && a.saving.Load() == 0
+}
+
+// putBack returns a range taken by nextSave to the write cache.
+func (a *PieceArray) putBack(from, to int64) {
+	a.listTLock.Lock()
+	defer a.listTLock.Unlock()
+	if a.toSave == nil {
+		a.toSaveSince = time.Now()
+	}
+	a.toSave = util.InsertRange(a.toSave, from, to)
+	a.saving.Add(from - to)
+}
+
+// nextSave takes a range to write from the write cache, it is counted as being
+// saved until file workers report it or it is put back. Adjacent validated
+// pieces are coalesced into one write of up to size bytes, a smaller range is
+// taken only if data waits longer than age or memory budget is used up.
+// Otherwise it returns how long to wait before the cache is old enough.
+func (a *PieceArray) nextSave(now time.Time, size int64, age time.Duration) (util.Pair[int64], time.Duration, bool) {
+	a.listTLock.Lock()
+	defer a.listTLock.Unlock()
+	if a.toSave == nil {
+		return util.Pair[int64]{}, 0, false
+	}
+
+	budget := a.budget.Load()
+	force := now.Sub(a.toSaveSince) >= age || budget > 0 && a.buffered.Load() >= budget
+	for node := a.toSave; node != nil; node = node.Next {
+		r := node.Value
+		if r.Second-r.First >= size || force {
+			r.Second = min(r.Second, r.First+size)
+			a.toSave = util.RemoveRange(a.toSave, r.First, r.Second)
+			if a.toSave == nil {
+				a.toSaveSince = time.Time{}
+			}
+			a.saving.Add(r.Second - r.First)
+			return r, 0, true
+		}
+	}
+	return util.Pair[int64]{}, age - now.Sub(a.toSaveSince), false
+}
+
+// saveRange makes a message for file workers with data of range r, the part
+// that does not fit into the file at its start goes back to the cache.
+func (a *PieceArray) saveRange(tf *TorrentFile, r util.Pair[int64], callback chan<- message.IsRangeSaved) message.SaveRange {
+	key, fileOffset, length := locateRange(tf, a, r.First, r.Second-r.First)
+	if r.First+length < r.Second {
+		a.putBack(r.First+length, r.Second)
+	}
+
+	firstPiece := r.First / a.pieceLength
+	lastPiece := (r.First + length - 1) / a.pieceLength
+	pieces := make([][]byte, lastPiece+1)
+	a.validLock.Lock()
+	for i := firstPiece; i <= lastPiece; i++ {
+		pieces[i] = a.validPieces[i]
+	}
+	a.validLock.Unlock()
+
+	return message.SaveRange{
+		InfoHash:    tf.InfoHash,
+		Pieces:      pieces,
+		PieceLength: a.pieceLength,
+		Offset:      r.First,
+		FileIndex:   key.Index,
+		FileOffset:  fileOffset,
+		Length:      length,
+		Callback:    callback,
+	}
+}
+
+// StartFlusher hands validated data over to file workers as soon as there is
+// enough of it, instead of file workers asking for it.
+func StartFlusher(ctx context.Context, pieces *PieceArray, tf *TorrentFile, ch message.PieceChannels, cfg *Config) {
+	timer := time.NewTimer(cfg.FlushAge)
+	defer timer.Stop()
+
+	for {
+		r, wait, ok := pieces.nextSave(time.Now(), cfg.FlushSize, cfg.FlushAge)
+		if ok {
+			msg := pieces.saveRange(tf, r, ch.CallBack)
+			msg.Done = ctx.Done()
+			// the range stays counted by flushed until file workers have it,
+			// it goes back to the cache if they never get it
+			select {
+			case ch.PostStatsChannel <- message.StatDiff{Validated: -msg.Length, Saving: msg.Length}:
+			case <-ctx.Done():
+				pieces.putBack(msg.Offset, msg.Offset+msg.Length)
+				return
+			}
+			select {
+			case ch.FileWorkerToSave <- msg:
+			case <-ctx.Done():
+				pieces.putBack(msg.Offset, msg.Offset+msg.Length)
+				return
+			}
+			continue
+		}
+
+		// the timer is only needed while something waits in the cache
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer.Reset(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-pieces.toSaveCh:
+		case <-timeout:
+		case <-ctx.Done():
+			return
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,79 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/util"
+)
+
+func TestFlush(t *testing.T) {
+	const size = 8
+	age := DefaultConfig().FlushAge
+
+	// three pieces of 4 bytes, the first file ends in the middle of the second piece
+	tf := TorrentFile{PieceLength: 4, Pieces: make([][20]byte, 3)}
+	tf.Files = []struct {
+		Length int64
+		Path   []string
+	}{
+		{Length: 6, Path: []string{"a.txt"}},
+		{Length: 6, Path: []string{"b.txt"}},
+	}
+
+	t.Run("coalesce", func(t *testing.T) {
+		a := InitPieceArray(12, 4)
+		now := time.Now()
+		a.addToSave(0, 4)
+		if _, wait, ok := a.nextSave(now, size, age); ok || wait <= 0 {
+			t.Fatalf("expected to wait for more data, got %v", wait)
+		}
+		a.addToSave(8, 12)
+		a.addToSave(4, 8)
+		r, _, ok := a.nextSave(now, size, age)
+		if !ok || r != (util.Pair[int64]{First: 0, Second: 8}) {
+			t.Fatalf("expected write of two adjacent pieces, got %v", r)
+		}
+		if _, _, ok := a.nextSave(now, size, age); ok {
+			t.Fatal("expected the rest to wait")
+		}
+		r, _, ok = a.nextSave(a.toSaveSince.Add(age), size, age)
+		if !ok || r != (util.Pair[int64]{First: 8, Second: 12}) {
+			t.Fatalf("expected old data to be written, got %v", r)
+		}
+	})
+
+	t.Run("file boundary", func(t *testing.T) {
+		a := InitPieceArray(12, 4)
+		a.validPieces[0], a.validPieces[1] = []byte("0123"), []byte("4567")
+		a.addToSave(0, 8)
+		r, _, _ := a.nextSave(time.Now(), size, age)
+		msg := a.saveRange(&tf, r, nil)
+		if msg.FileIndex != 0 || msg.FileOffset != 0 || msg.Length != 6 {
+			t.Fatalf("expected write up to the end of the first file, got %+v", msg)
+		}
+		if a.toSave == nil || a.toSave.Value != (util.Pair[int64]{First: 6, Second: 8}) {
+			t.Fatal("expected the rest to be returned to the cache")
+		}
+		if n := a.saving.Load(); n != 6 {
+			t.Fatalf("expected 6 bytes being saved, got %d", n)
+		}
+	})
+
+	t.Run("flushed", func(t *testing.T) {
+		a := InitPieceArray(12, 4)
+		a.addToSave(0, 8)
+		r, _, _ := a.nextSave(time.Now(), size, age)
+		// a range taken from the cache is not flushed until it is saved
+		if a.flushed() {
+			t.Fatal("expected taken range not to be flushed")
+		}
+		a.putBack(r.First, r.Second)
+		if a.flushed() || a.saving.Load() != 0 {
+			t.Fatal("expected range to be back in the cache")
+		}
+		if r, _, _ := a.nextSave(time.Now(), size, age); r != (util.Pair[int64]{First: 0, Second: 8}) {
+			t.Fatalf("expected the range to be taken again, got %v", r)
+		}
+	})
+}
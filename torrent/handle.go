@@ -0,0 +1,334 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/username918r818/torrent-client/file"
+	"github.com/username918r818/torrent-client/limit"
+	"github.com/username918r818/torrent-client/message"
+)
+
+const (
+	CommandRecheck = iota
+	CommandPause
+	CommandResume
+	CommandQueue // pause by the queue of the session
+)
+
+// TorrentState is the lifecycle state of a torrent reported by its supervisor.
+type TorrentState int32
+
+const (
+	StateStarting    TorrentState = iota // files are checked and allocated
+	StateChecking                        // data on disk is hashed
+	StateDownloading                     // wanted files are not complete
+	StateSeeding                         // all wanted files are complete
+	StatePaused                          // peers are disconnected, nothing is announced
+	StateQueued                          // paused until the queue of the session starts it
+	StateStopping                        // data is written before the supervisor returns
+	StateStopped                         // the supervisor has returned
+	StateFailed                          // the supervisor could not start, see Handle.Err
+)
+
+func (s TorrentState) String() string {
+	switch s {
+	case StateStarting:
+		return "starting"
+	case StateChecking:
+		return "checking"
+	case StateDownloading:
+		return "downloading"
+	case StateSeeding:
+		return "seeding"
+	case StatePaused:
+		return "paused"
+	case StateQueued:
+		return "queued"
+	case StateStopping:
+		return "stopping"
+	case StateStopped:
+		return "stopped"
+	case StateFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+var ErrStopped = errors.New("handle: torrent is stopped")
+
+// Handle is used to control a torrent after its supervisor is started.
+type Handle struct {
+	File       TorrentFile
+	totalBytes int64
+	pieces     *PieceArray
+	control    chan message.Command
+	filesReady chan struct{} // closed when the supervisor opened files
+	stopped    chan struct{} // closed when the supervisor returned
+	state      atomic.Int32
+	removeData atomic.Bool  // files are deleted when the supervisor returns
+	peers      atomic.Int64 // peer workers running
+	knownPeers atomic.Int64 // peers the supervisor knows about, connected or not
+	down, up   *limit.Bucket
+	peerDown   *limit.Group // buckets of every peer
+	peerUp     *limit.Group
+
+	mu         sync.Mutex // guards fields below
+	started    bool
+	priorities []FilePriority
+	storage    file.Storage // files on disk if nil
+	allocMode  file.AllocMode
+	suffix     bool   // incomplete files get IncompleteSuffix
+	moveTo     string // complete torrent is moved there if not empty
+	cache      *ReadCache
+	err        error       // why the supervisor failed
+	seedLimits *SeedLimits // limits of the session if nil
+	transfer   TransferStats
+}
+
+// startOptions are settings of a handle a supervisor starts with.
+type startOptions struct {
+	priorities []FilePriority
+	storage    file.Storage
+	allocMode  file.AllocMode
+	suffix     bool
+	moveTo     string
+}
+
+func NewHandle(tf TorrentFile) *Handle {
+	h := &Handle{File: tf, control: make(chan message.Command), filesReady: make(chan struct{}), stopped: make(chan struct{}), suffix: true}
+	h.down, h.up = limit.NewBucket(0), limit.NewBucket(0)
+	h.peerDown, h.peerUp = limit.NewGroup(0), limit.NewGroup(0)
+	for _, f := range tf.Files {
+		h.totalBytes += f.Length
+	}
+	pieces := InitPieceArray(h.totalBytes, tf.PieceLength)
+	h.pieces = &pieces
+	h.priorities = make([]FilePriority, len(tf.Files))
+	for i := range h.priorities {
+		h.priorities[i] = PriorityNormal
+	}
+	return h
+}
+
+// start marks the handle as used by a supervisor and returns settings the
+// supervisor starts with. A handle is started only once, even after its
+// supervisor returns.
+func (h *Handle) start() (startOptions, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.started {
+		return startOptions{}, errors.New("handle: torrent was started already")
+	}
+	h.started = true
+	return startOptions{slices.Clone(h.priorities), h.storage, h.allocMode, h.suffix, h.moveTo}, nil
+}
+
+func (h *Handle) State() TorrentState {
+	return TorrentState(h.state.Load())
+}
+
+func (h *Handle) setState(s TorrentState) {
+	h.state.Store(int32(s))
+}
+
+// Err returns why the torrent failed to start, if it did.
+func (h *Handle) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+func (h *Handle) fail(err error) {
+	h.mu.Lock()
+	h.err = err
+	h.mu.Unlock()
+	h.setState(StateFailed)
+}
+
+// setFiles is called by the supervisor once files are opened.
+func (h *Handle) setFiles(storage file.Storage) {
+	h.mu.Lock()
+	h.storage = storage
+	h.mu.Unlock()
+	close(h.filesReady)
+}
+
+// SetAllocMode selects how files on disk are allocated, it has to be called
+// before the torrent is started.
+func (h *Handle) SetAllocMode(m file.AllocMode) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.started {
+		return errors.New("handle: can't change allocation of running torrent")
+	}
+	h.allocMode = m
+	return nil
+}
+
+// SetIncompleteSuffix selects whether files are written with IncompleteSuffix
+// until they are complete, it is on by default.
+func (h *Handle) SetIncompleteSuffix(on bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.started {
+		return errors.New("handle: can't change file names of running torrent")
+	}
+	h.suffix = on
+	return nil
+}
+
+// SetMoveTo makes the supervisor move files to dir once the torrent is
+// complete, files on another filesystem are copied.
+func (h *Handle) SetMoveTo(dir string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.started {
+		return errors.New("handle: can't change directory of running torrent")
+	}
+	h.moveTo = dir
+	return nil
+}
+
+// SetReadCache makes readers created after the call read pieces through c.
+func (h *Handle) SetReadCache(c *ReadCache) {
+	h.mu.Lock()
+	h.cache = c
+	h.mu.Unlock()
+}
+
+// SetStorage replaces files on disk with another storage, it has to be called
+// before the torrent is started.
+func (h *Handle) SetStorage(s file.Storage) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.started {
+		return errors.New("handle: can't change storage of running torrent")
+	}
+	h.storage = s
+	return nil
+}
+
+// Done returns a channel closed when the supervisor of the torrent returns,
+// it is stopped or removed from its session.
+func (h *Handle) Done() <-chan struct{} {
+	return h.stopped
+}
+
+// command sends cmd to the supervisor and waits for its reply, it fails with
+// ErrStopped once the supervisor returned.
+func (h *Handle) command(ctx context.Context, id int) error {
+	reply := make(chan error, 1)
+	select {
+	case h.control <- message.Command{Id: id, Reply: reply}:
+	case <-h.stopped:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-reply:
+		return err
+	case <-h.stopped:
+		// the supervisor may reply right before it returns
+		select {
+		case err := <-reply:
+			return err
+		default:
+			return ErrStopped
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Recheck hashes data on disk again while the torrent is running, new tasks
+// are not given to peers until it is done.
+func (h *Handle) Recheck(ctx context.Context) error {
+	return h.command(ctx, CommandRecheck)
+}
+
+// Pause disconnects peers and stops announces, data waiting in memory is
+// written to disk. Pieces and files are kept as they are.
+func (h *Handle) Pause(ctx context.Context) error {
+	return h.command(ctx, CommandPause)
+}
+
+// Resume connects to peers of a paused torrent again.
+func (h *Handle) Resume(ctx context.Context) error {
+	return h.command(ctx, CommandResume)
+}
+
+// Pieces gives access to piece states and to sequential mode and deadlines of
+// the picker.
+func (h *Handle) Pieces() *PieceArray {
+	return h.pieces
+}
+
+func (h *Handle) FilePriorities() []FilePriority {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.priorities)
+}
+
+// SetFilePriority changes order in which pieces are downloaded. Skipped files
+// are not created on disk, so a file can't be skipped or unskipped once the
+// torrent is started.
+func (h *Handle) SetFilePriority(index int, p FilePriority) error {
+	if index < 0 || index >= len(h.File.Files) {
+		return errors.New("handle: wrong file index")
+	}
+	if p < PrioritySkip || p > PriorityHigh {
+		return errors.New("handle: wrong priority")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.started && (p == PrioritySkip) != (h.priorities[index] == PrioritySkip) {
+		return errors.New("handle: can't skip or unskip a file of running torrent")
+	}
+	h.priorities[index] = p
+	h.pieces.setFilePriorities(&h.File, h.priorities)
+	return nil
+}
+
+// NewReader reads the whole payload of the torrent, files go one after another.
+// It waits for the supervisor to open files. Reads of bytes that are never
+// downloaded because their files are skipped fail with ErrSkipped.
+func (h *Handle) NewReader(ctx context.Context) (*Reader, error) {
+	select {
+	case <-h.filesReady:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	h.mu.Lock()
+	storage, cache := h.storage, h.cache
+	h.mu.Unlock()
+	r := NewReader(ctx, &h.File, h.pieces, storage, 0, h.totalBytes)
+	r.cache = cache
+	return r, nil
+}
+
+// NewFileReader reads a single file of the torrent.
+func (h *Handle) NewFileReader(ctx context.Context, index int) (*Reader, error) {
+	if index < 0 || index >= len(h.File.Files) {
+		return nil, errors.New("handle: wrong file index")
+	}
+	if h.FilePriorities()[index] == PrioritySkip {
+		return nil, errors.New("handle: file is skipped")
+	}
+
+	r, err := h.NewReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range h.File.Files[:index] {
+		r.offset += f.Length
+	}
+	r.length = h.File.Files[index].Length
+	return r, nil
+}
@@ -0,0 +1,143 @@
+package torrent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/username918r818/torrent-client/file"
+)
+
+const IncompleteSuffix = ".part"
+
+// layout places files of a torrent on disk. Files are written with
+// IncompleteSuffix until all their data is saved, and a complete torrent may be
+// moved to another directory. Files are looked up in the same order on start:
+// moved, complete, incomplete.
+type layout struct {
+	tf     *TorrentFile
+	suffix bool
+	moveTo string
+	files  TorrentFile // tf with paths of files where they are now
+}
+
+func newLayout(tf *TorrentFile, suffix bool, moveTo string) *layout {
+	l := &layout{tf: tf, suffix: suffix, moveTo: moveTo, files: *tf}
+	l.files.Files = slices.Clone(tf.Files)
+	for i, f := range tf.Files {
+		switch {
+		case moveTo != "" && exists(l.movedPath(i)):
+			l.files.Files[i].Path = l.movedPath(i)
+		case suffix && f.Length > 0 && !exists(f.Path):
+			l.files.Files[i].Path = l.incompletePath(i)
+		}
+	}
+	return l
+}
+
+func exists(path []string) bool {
+	_, err := os.Stat(filepath.Join(path...))
+	return err == nil
+}
+
+func (l *layout) movedPath(i int) []string {
+	return append([]string{l.moveTo}, l.tf.Files[i].Path...)
+}
+
+func (l *layout) incompletePath(i int) []string {
+	path := slices.Clone(l.tf.Files[i].Path)
+	path[len(path)-1] += IncompleteSuffix
+	return path
+}
+
+// complete gives file i its final name, storage opens it by that name once
+// its open file is closed.
+func (l *layout) complete(storage diskStorage, i int) error {
+	if !slices.Equal(l.files.Files[i].Path, l.incompletePath(i)) {
+		return nil
+	}
+	if err := os.Rename(filepath.Join(l.files.Files[i].Path...), filepath.Join(l.tf.Files[i].Path...)); err != nil {
+		return err
+	}
+	l.files.Files[i].Path = l.tf.Files[i].Path
+	storage.AddPath(file.Key{InfoHash: l.tf.InfoHash, Index: i}, filepath.Join(l.files.Files[i].Path...))
+	return nil
+}
+
+// move moves wanted files to the move directory, storage opens them from
+// there.
+func (l *layout) move(storage diskStorage, priorities []FilePriority) error {
+	for i := range l.files.Files {
+		src, dst := l.files.Files[i].Path, l.movedPath(i)
+		if priorities[i] == PrioritySkip || slices.Equal(src, dst) {
+			continue
+		}
+		key := file.Key{InfoHash: l.tf.InfoHash, Index: i}
+		if err := storage.Close(key); err != nil {
+			return err
+		}
+		moveErr := file.Move(filepath.Join(src...), filepath.Join(dst...))
+		if moveErr == nil {
+			l.files.Files[i].Path = dst
+		}
+		// the file is added again where it is now, even if it was not moved
+		storage.AddPath(key, filepath.Join(l.files.Files[i].Path...))
+		if moveErr != nil {
+			return moveErr
+		}
+	}
+	return nil
+}
+
+// close syncs and closes wanted files and the part file in storage.
+func (l *layout) close(storage file.Storage, priorities []FilePriority) error {
+	var errs []error
+	closeFile := func(key file.Key) {
+		if err := storage.Sync(key); err != nil {
+			errs = append(errs, err)
+		}
+		if err := storage.Close(key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	skipped := false
+	for i := range l.files.Files {
+		if priorities[i] == PrioritySkip {
+			skipped = true
+			continue
+		}
+		closeFile(file.Key{InfoHash: l.tf.InfoHash, Index: i})
+	}
+	if skipped {
+		closeFile(file.Key{InfoHash: l.tf.InfoHash, Index: file.PartIndex})
+	}
+	return errors.Join(errs...)
+}
+
+// remove closes wanted files in storage and deletes them with the part file,
+// directories of the torrent are deleted if they are left empty.
+func (l *layout) remove(storage file.Storage, priorities []FilePriority) error {
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for i, f := range l.files.Files {
+		if priorities[i] == PrioritySkip {
+			continue
+		}
+		keep(storage.Close(file.Key{InfoHash: l.tf.InfoHash, Index: i}))
+		keep(os.Remove(filepath.Join(f.Path...)))
+		// the move directory itself is kept, removing directories with other
+		// files in them fails
+		base := len(f.Path) - len(l.tf.Files[i].Path)
+		for dir := f.Path[:len(f.Path)-1]; len(dir) > base; dir = dir[:len(dir)-1] {
+			os.Remove(filepath.Join(dir...))
+		}
+	}
+	keep(storage.Close(file.Key{InfoHash: l.tf.InfoHash, Index: file.PartIndex}))
+	keep(os.Remove(PartPath(l.tf.InfoHash)))
+	return firstErr
+}
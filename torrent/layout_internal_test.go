@@ -0,0 +1,86 @@
+package torrent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/username918r818/torrent-client/file"
+)
+
+func TestLayout(t *testing.T) {
+	tempDir := t.TempDir()
+	moveTo := filepath.Join(tempDir, "done")
+	h := newPriorityTorrent(tempDir)
+	h.File.Files[2].Length = 0
+	if err := os.WriteFile(filepath.Join(tempDir, "b.txt"), []byte("67"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := newLayout(&h.File, true, moveTo)
+	paths := make([]string, len(l.files.Files))
+	for i, f := range l.files.Files {
+		paths[i] = filepath.Join(f.Path...)
+	}
+	// missing file is incomplete, existing and empty files are not
+	if paths[0] != filepath.Join(tempDir, "a.txt.part") || paths[1] != filepath.Join(tempDir, "b.txt") || paths[2] != filepath.Join(tempDir, "c.txt") {
+		t.Fatalf("unexpected paths: %v", paths)
+	}
+
+	disk := file.NewDisk()
+	if err := allocFiles(&l.files, h.FilePriorities(), file.AllocSparse, disk); err != nil {
+		t.Fatal(err)
+	}
+	key := file.Key{InfoHash: h.File.InfoHash, Index: 0}
+	if _, err := disk.WriteAt(key, []byte("012345"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("complete", func(t *testing.T) {
+		if err := l.complete(disk, 0); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+			t.Fatalf("expected incomplete file to be renamed, got %v", err)
+		}
+		// the file stays open under its new name
+		if _, err := disk.WriteAt(key, []byte("5"), 5); err != nil {
+			t.Fatal(err)
+		}
+		if data, err := os.ReadFile(filepath.Join(tempDir, "a.txt")); err != nil || string(data) != "012345" {
+			t.Fatalf("unexpected complete file: %q, %v", data, err)
+		}
+
+		// once its open file is closed, it is opened again by the final name
+		disk.SetMaxOpen(1)
+		other := file.Key{InfoHash: h.File.InfoHash, Index: 1}
+		if _, err := disk.ReadAt(other, make([]byte, 2), 0); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 6)
+		if _, err := disk.ReadAt(key, buf, 0); err != nil || string(buf) != "012345" {
+			t.Fatalf("expected complete file to be readable, got %q, %v", buf, err)
+		}
+		disk.SetMaxOpen(file.DefaultMaxOpen)
+	})
+
+	t.Run("move", func(t *testing.T) {
+		if err := l.move(disk, h.FilePriorities()); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+			if _, err := os.Stat(filepath.Join(moveTo, tempDir, name)); err != nil {
+				t.Fatalf("expected %s to be moved, got %v", name, err)
+			}
+		}
+		buf := make([]byte, 6)
+		if _, err := disk.ReadAt(key, buf, 0); err != nil || string(buf) != "012345" {
+			t.Fatalf("expected moved file to be readable, got %q, %v", buf, err)
+		}
+
+		// moved files are found on the next start
+		if l := newLayout(&h.File, true, moveTo); l.files.Files[0].Path[0] != moveTo {
+			t.Fatalf("expected moved path, got %v", l.files.Files[0].Path)
+		}
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"log/slog"
 	"net"
+	"slices"
 	"strconv"
 	"time"
 
@@ -26,6 +27,7 @@ const (
 	IdPiece
 	IdCancel
 	IdPort
+	IdSnubbed   byte = 252
 	IdKeepAlive byte = 253
 	IdReady     byte = 254
 	IdDead      byte = 255
@@ -39,15 +41,30 @@ const (
 	BlockSize = 1 << 14
 )
 
+var errSnubbed = errors.New("peer: snubbed")
+
 type peerStatus struct {
 	choked     bool
 	interested bool
 }
 
-func readMessage(conn net.Conn, peerId [6]byte) (message.PeerMessage, error) {
+// readerEvent is what the reader tells the worker about a received message,
+// index and begin are set for blocks.
+type readerEvent struct {
+	id           byte
+	index, begin uint32
+}
+
+type pendingRequest struct {
+	index, begin, length uint32
+	sent                 time.Duration // by the peer clock
+	retried              bool
+}
+
+func readMessage(conn net.Conn, peerId [6]byte, timeout time.Duration) (message.PeerMessage, error) {
 	msg := message.PeerMessage{}
 	msg.PeerId = peerId
-	conn.SetReadDeadline(time.Now().Add(3 * time.Minute))
+	conn.SetReadDeadline(time.Now().Add(timeout))
 	buf := make([]byte, 4)
 	if _, err := io.ReadFull(conn, buf); err != nil {
 		return msg, err
@@ -59,7 +76,7 @@ func readMessage(conn net.Conn, peerId [6]byte) (message.PeerMessage, error) {
 	}
 
 	buf = make([]byte, msg.Length)
-	conn.SetReadDeadline(time.Now().Add(3 * time.Minute))
+	conn.SetReadDeadline(time.Now().Add(timeout))
 	if _, err := io.ReadFull(conn, buf); err != nil {
 		return msg, err
 	}
@@ -71,36 +88,61 @@ func readMessage(conn net.Conn, peerId [6]byte) (message.PeerMessage, error) {
 	return msg, nil
 }
 
-func infiniteReadingMessage(conn net.Conn, peerId [6]byte, toWriter chan<- byte, toSup chan<- message.PeerMessage, toPiece chan<- message.Block, a *PieceArray) {
+func infiniteReadingMessage(ctx context.Context, conn net.Conn, peerId [6]byte, timeout time.Duration, toWriter chan<- readerEvent, toSup chan<- message.PeerMessage, toPiece chan<- message.Block, a *PieceArray) {
+	// the worker does not read events once it is stopped
+	toWorker := func(event readerEvent) bool {
+		select {
+		case toWriter <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 	for {
-		msg, err := readMessage(conn, peerId)
+		msg, err := readMessage(conn, peerId, timeout)
 		if err != nil {
 			slog.Error("peer reader: " + err.Error())
-			toWriter <- IdDead
+			toWorker(readerEvent{id: IdDead})
 			return
 		}
 		if msg.Id == 0 && msg.Length == 0 {
 			msg.Id = IdKeepAlive
 		}
+		if msg.Id == IdPiece && len(msg.Payload) < 8 {
+			slog.Error("peer reader: short piece message")
+			toWorker(readerEvent{id: IdDead})
+			return
+		}
 		toSup <- msg
-		toWriter <- msg.Id
+		event := readerEvent{id: msg.Id}
+		if msg.Id == IdPiece {
+			event.index, event.begin = binary.BigEndian.Uint32(msg.Payload[:4]), binary.BigEndian.Uint32(msg.Payload[4:8])
+		}
+		if !toWorker(event) {
+			return
+		}
 		if msg.Id == IdPiece {
 			index, begin, block := int(binary.BigEndian.Uint32(msg.Payload[:4])), int64(binary.BigEndian.Uint32(msg.Payload[4:8])), msg.Payload[8:]
 			tmpB, err := UpdatePiece(index, a)
 			if err != nil {
-				slog.Error("Peer: " + err.Error())
-				toWriter <- IdDead
-				return
+				// late block of a piece that was reassigned and completed by another peer
+				slog.Info("Peer: " + err.Error())
+				continue
 			}
 			copy(tmpB[begin:], block)
+			a.received.Add(int64(len(block)))
 			var tmpOffset, length int64 = int64(index)*int64(a.pieceLength) + int64(begin), int64(len(block))
-			toPiece <- message.Block{Offset: tmpOffset, Length: length}
+			select {
+			case toPiece <- message.Block{Offset: tmpOffset, Length: length}:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
 
-func writeMessage(conn net.Conn, msg []byte) error {
-	conn.SetWriteDeadline(time.Now().Add(3 * time.Minute))
+func writeMessage(conn net.Conn, msg []byte, timeout time.Duration) error {
+	conn.SetWriteDeadline(time.Now().Add(timeout))
 	_, err := conn.Write(msg)
 	if err != nil {
 		return err
@@ -109,28 +151,46 @@ func writeMessage(conn net.Conn, msg []byte) error {
 	return nil
 }
 
-func sendBitField(conn net.Conn, length int) error {
+func sendBitField(conn net.Conn, length int, timeout time.Duration) error {
 	msg := make([]byte, 5+length)
 	binary.BigEndian.PutUint32(msg[0:4], uint32(length+1))
 	msg[4] = IdBitfield
-	return writeMessage(conn, msg)
+	return writeMessage(conn, msg, timeout)
 }
 
-func sendInterested(conn net.Conn) error {
+func sendInterested(conn net.Conn, timeout time.Duration) error {
 	msg := make([]byte, 5)
 	binary.BigEndian.PutUint32(msg[0:4], 1)
 	msg[4] = IdInterested
-	return writeMessage(conn, msg)
+	return writeMessage(conn, msg, timeout)
 }
 
-func sendRequest(conn net.Conn, index, begin, length uint32) error {
+func sendRequest(conn net.Conn, index, begin, length uint32, timeout time.Duration) error {
 	msg := make([]byte, 17)
 	binary.BigEndian.PutUint32(msg[0:4], 13)
 	msg[4] = IdRequest
 	binary.BigEndian.PutUint32(msg[5:9], index)
 	binary.BigEndian.PutUint32(msg[9:13], begin)
 	binary.BigEndian.PutUint32(msg[13:17], length)
-	return writeMessage(conn, msg)
+	return writeMessage(conn, msg, timeout)
+}
+
+func sendCancel(conn net.Conn, index, begin, length uint32, timeout time.Duration) error {
+	msg := make([]byte, 17)
+	binary.BigEndian.PutUint32(msg[0:4], 13)
+	msg[4] = IdCancel
+	binary.BigEndian.PutUint32(msg[5:9], index)
+	binary.BigEndian.PutUint32(msg[9:13], begin)
+	binary.BigEndian.PutUint32(msg[13:17], length)
+	return writeMessage(conn, msg, timeout)
+}
+
+func cancelRequests(conn net.Conn, pending []pendingRequest, timeout time.Duration) {
+	for _, r := range pending {
+		if err := sendCancel(conn, r.index, r.begin, r.length, timeout); err != nil {
+			return
+		}
+	}
 }
 
 func handshakeRead(conn net.Conn, infoHash [20]byte) error {
@@ -160,7 +220,7 @@ func handshakeRead(conn net.Conn, infoHash [20]byte) error {
 	return nil
 }
 
-func handshakeWrite(conn net.Conn, pstr string, infoHash [20]byte, peerId [20]byte) error {
+func handshakeWrite(conn net.Conn, pstr string, infoHash [20]byte, peerId [20]byte, timeout time.Duration) error {
 	pstrB := []byte(pstr)
 	var reserved [8]byte
 	msg := make([]byte, 49+len(pstrB))
@@ -169,25 +229,28 @@ func handshakeWrite(conn net.Conn, pstr string, infoHash [20]byte, peerId [20]by
 	copy(msg[1+len(pstrB):], reserved[:])
 	copy(msg[9+len(pstrB):], infoHash[:])
 	copy(msg[29+len(pstrB):], peerId[:])
-	return writeMessage(conn, msg)
+	return writeMessage(conn, msg, timeout)
 }
 
-func download(conn net.Conn, task message.DownloadRange, ch message.PeerChannels, a *PieceArray, peer [6]byte, ps *peerStatus, fromReader <-chan byte) error {
+func download(conn net.Conn, task message.DownloadRange, ch message.PeerChannels, a *PieceArray, peer [6]byte, ps *peerStatus, fromReader <-chan readerEvent, cfg *Config) error {
 	// slog.Info("Peer: downloading")
 	curIndex := task.Offset
 
-	requestCounter := 0
+	// time our reads wait for download limits is not the peer's fault
+	clock := peerClock(conn)
+	pending := make([]pendingRequest, 0, 5)
+	lastData := clock()
 
 	if !ps.interested {
-		err := sendInterested(conn)
+		err := sendInterested(conn, cfg.PeerTimeout)
 		ps.interested = true
 		if err != nil {
 			return err
 		}
 	}
 
-	for curIndex < task.Length+task.Offset || requestCounter > 0 {
-		for !ps.choked && curIndex < task.Length+task.Offset && requestCounter < 5 {
+	for curIndex < task.Length+task.Offset || len(pending) > 0 {
+		for !ps.choked && curIndex < task.Length+task.Offset && len(pending) < 5 {
 			index := curIndex / a.pieceLength
 			begin := curIndex % a.pieceLength
 			length := int64(BlockSize)
@@ -197,32 +260,80 @@ func download(conn net.Conn, task message.DownloadRange, ch message.PeerChannels
 			if curIndex+length > task.Offset+task.Length {
 				length = task.Offset + task.Length - curIndex
 			}
-			err := sendRequest(conn, uint32(index), uint32(begin), uint32(length))
+			err := sendRequest(conn, uint32(index), uint32(begin), uint32(length), cfg.PeerTimeout)
 			if err != nil {
 				return err
 			}
-			requestCounter++
+			pending = append(pending, pendingRequest{uint32(index), uint32(begin), uint32(length), clock(), false})
 			curIndex += length
 		}
-		exitLoop := false
-		for !exitLoop {
-			select {
-			case msgId := <-fromReader:
-				switch {
-				case msgId == IdChoke:
-					ps.choked = true
-					return nil
-				case msgId == IdUnchoke:
-					ps.choked = false
-				case msgId == IdPiece:
-					requestCounter--
-				case msgId == IdDead:
-					return errors.New("peer: received dead signal from reader")
+
+		// time spent choked is not counted, requests are not sent then
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !ps.choked {
+			wait := cfg.SnubTimeout - (clock() - lastData)
+			if len(pending) > 0 {
+				if untilTimeout := cfg.RequestTimeout - (clock() - pending[0].sent); untilTimeout < wait {
+					wait = untilTimeout
 				}
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
 
-			default:
-				exitLoop = true
+		event, timedOut := readerEvent{}, false
+		select {
+		case event = <-fromReader:
+		case <-timeout:
+			timedOut = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+
+		switch {
+		case !timedOut:
+			switch event.id {
+			case IdChoke:
+				ps.choked = true
+				return nil
+			case IdUnchoke:
+				ps.choked = false
+				lastData = clock()
+			case IdPiece:
+				// a late answer to a cancelled request is not in pending anymore
+				i := slices.IndexFunc(pending, func(r pendingRequest) bool {
+					return r.index == event.index && r.begin == event.begin
+				})
+				if i >= 0 {
+					pending = slices.Delete(pending, i, i+1)
+				}
+				lastData = clock()
+			case IdDead:
+				return errors.New("peer: received dead signal from reader")
+			}
+
+		default:
+			snubbed := clock()-lastData >= cfg.SnubTimeout
+			if !snubbed && (len(pending) == 0 || clock()-pending[0].sent < cfg.RequestTimeout) {
+				// the clock stood still while reads waited for limits
+				continue
+			}
+			if len(pending) == 0 || snubbed || pending[0].retried {
+				cancelRequests(conn, pending, cfg.PeerTimeout)
+				return errSnubbed
+			}
+			req := pending[0]
+			pending = pending[1:]
+			if err := sendCancel(conn, req.index, req.begin, req.length, cfg.PeerTimeout); err != nil {
+				return err
 			}
+			if err := sendRequest(conn, req.index, req.begin, req.length, cfg.PeerTimeout); err != nil {
+				return err
+			}
+			req.sent, req.retried = clock(), true
+			pending = append(pending, req)
 		}
 	}
 
@@ -234,12 +345,16 @@ func download(conn net.Conn, task message.DownloadRange, ch message.PeerChannels
 	return nil
 }
 
-func StartPeerWorker(ctx context.Context, ch message.PeerChannels, a *PieceArray, peer [6]byte, infoHash [20]byte, peerId [20]byte) {
+func StartPeerWorker(ctx context.Context, ch message.PeerChannels, a *PieceArray, peer [6]byte, infoHash [20]byte, peerId [20]byte, bw bandwidth, cfg *Config) {
+	// the reader stops with the worker
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	ip := fmt.Sprintf("%d.%d.%d.%d", peer[0], peer[1], peer[2], peer[3])
 	port := int64(peer[4])<<8 | int64(peer[5])
 	addr := ip + ":" + strconv.FormatInt(port, 10)
-	conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	rawConn, err := dialer.DialContext(ctx, "tcp", addr)
 	death := func(err error) {
 		slog.Info("Peer: " + err.Error())
 		msg := message.PeerMessage{}
@@ -252,8 +367,12 @@ func StartPeerWorker(ctx context.Context, ch message.PeerChannels, a *PieceArray
 		death(err)
 		return
 	}
+	conn, release := bw.limit(ctx, rawConn)
+	defer release()
+	// closing the connection stops the reader and blocked writes too
+	context.AfterFunc(ctx, func() { conn.Close() })
 
-	err = handshakeWrite(conn, BitTorrentPstr, infoHash, peerId)
+	err = handshakeWrite(conn, BitTorrentPstr, infoHash, peerId, cfg.PeerTimeout)
 
 	if err != nil {
 		death(err)
@@ -267,7 +386,7 @@ func StartPeerWorker(ctx context.Context, ch message.PeerChannels, a *PieceArray
 		return
 	}
 
-	msg, err := readMessage(conn, peer)
+	msg, err := readMessage(conn, peer, cfg.PeerTimeout)
 
 	if err != nil {
 		death(err)
@@ -281,20 +400,24 @@ func StartPeerWorker(ctx context.Context, ch message.PeerChannels, a *PieceArray
 		return
 	}
 
-	// err = sendBitField(conn, len(a.pieces))
+	// err = sendBitField(conn, len(a.pieces), cfg.PeerTimeout)
 
 	// if err != nil {
 	// 	death(err)
 	// 	return
 	// }
 
-	fromReader := make(chan byte)
+	fromReader := make(chan readerEvent)
 
-	go infiniteReadingMessage(conn, peer, fromReader, ch.PeerMessageChannel, ch.DownloadedChannel, a)
+	go infiniteReadingMessage(ctx, conn, peer, cfg.PeerTimeout, fromReader, ch.PeerMessageChannel, ch.DownloadedChannel, a)
 
-	time.Sleep(5 * time.Second)
+	select {
+	case <-time.After(cfg.InterestedDelay):
+	case <-ctx.Done():
+		return
+	}
 
-	err = sendInterested(conn)
+	err = sendInterested(conn, cfg.PeerTimeout)
 
 	if err != nil {
 		death(err)
@@ -311,7 +434,12 @@ func StartPeerWorker(ctx context.Context, ch message.PeerChannels, a *PieceArray
 		case task := <-ch.ToDownload:
 			// slog.Info("Peer worker: got a task")
 
-			err = download(conn, task, ch, a, peer, &ps, fromReader)
+			err = download(conn, task, ch, a, peer, &ps, fromReader, cfg)
+			if errors.Is(err, errSnubbed) {
+				slog.Info("Peer: snubbed, giving task back")
+				ch.PeerMessageChannel <- message.PeerMessage{PeerId: peer, Id: IdSnubbed}
+				break
+			}
 			if err != nil {
 				death(err)
 				timer.Stop()
@@ -320,14 +448,14 @@ func StartPeerWorker(ctx context.Context, ch message.PeerChannels, a *PieceArray
 		case <-timer.C:
 			timer.Stop()
 			var keepAlive [4]byte
-			err := writeMessage(conn, keepAlive[:])
+			err := writeMessage(conn, keepAlive[:], cfg.PeerTimeout)
 			if err != nil {
 				death(err)
 				timer.Stop()
 				return
 			}
-		case byteId := <-fromReader:
-			switch byteId {
+		case event := <-fromReader:
+			switch event.id {
 			case IdChoke:
 				ps.choked = true
 
@@ -336,6 +464,8 @@ func StartPeerWorker(ctx context.Context, ch message.PeerChannels, a *PieceArray
 
 			case IdDead:
 				death(errors.New("peer: reader died"))
+				timer.Stop()
+				return
 			}
 
 		case <-ctx.Done():
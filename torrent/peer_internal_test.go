@@ -0,0 +1,95 @@
+package torrent
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/message"
+)
+
+// readRequests collects ids of request and cancel messages written to conn.
+func readRequests(conn net.Conn) <-chan byte {
+	ids := make(chan byte, 16)
+	go func() {
+		defer close(ids)
+		var msg [17]byte
+		for {
+			if _, err := io.ReadFull(conn, msg[:]); err != nil {
+				return
+			}
+			ids <- msg[4]
+		}
+	}()
+	return ids
+}
+
+func expectId(t *testing.T, ids <-chan byte, id byte) {
+	t.Helper()
+	select {
+	case got := <-ids:
+		if got != id {
+			t.Fatalf("expected message %d, got %d", id, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected message %d, got none", id)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	task := message.DownloadRange{PieceLength: 4, Offset: 0, Length: 4}
+
+	start := func(t *testing.T, requestTimeout, snubTimeout time.Duration) (<-chan byte, chan readerEvent, chan message.PeerMessage, <-chan error) {
+		cfg := DefaultConfig()
+		cfg.PeerTimeout, cfg.RequestTimeout, cfg.SnubTimeout = time.Minute, requestTimeout, snubTimeout
+		local, remote := net.Pipe()
+		t.Cleanup(func() { local.Close(); remote.Close() })
+		a := InitPieceArray(4, 4)
+		fromReader := make(chan readerEvent)
+		toSup := make(chan message.PeerMessage, 1)
+		ps := peerStatus{choked: false, interested: true}
+		done := make(chan error, 1)
+		go func() {
+			done <- download(local, task, message.PeerChannels{PeerMessageChannel: toSup}, &a, [6]byte{}, &ps, fromReader, &cfg)
+		}()
+		return readRequests(remote), fromReader, toSup, done
+	}
+
+	t.Run("request timeout", func(t *testing.T) {
+		ids, fromReader, toSup, done := start(t, 50*time.Millisecond, time.Second)
+
+		expectId(t, ids, IdRequest)
+		expectId(t, ids, IdCancel)
+		expectId(t, ids, IdRequest)
+		fromReader <- readerEvent{id: IdPiece, index: 0, begin: 0}
+
+		if err := <-done; err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if msg := <-toSup; msg.Id != IdReady {
+			t.Fatalf("expected ready message, got %d", msg.Id)
+		}
+	})
+
+	t.Run("snubbed", func(t *testing.T) {
+		ids, _, _, done := start(t, time.Second, 50*time.Millisecond)
+
+		expectId(t, ids, IdRequest)
+		expectId(t, ids, IdCancel)
+		if err := <-done; !errors.Is(err, errSnubbed) {
+			t.Fatalf("expected snubbed error, got %v", err)
+		}
+	})
+
+	t.Run("choked", func(t *testing.T) {
+		ids, fromReader, _, done := start(t, time.Second, time.Second)
+
+		expectId(t, ids, IdRequest)
+		fromReader <- readerEvent{id: IdChoke}
+		if err := <-done; err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+}
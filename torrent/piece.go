@@ -5,9 +5,9 @@ import (
 	"crypto/sha1"
 	"errors"
 	"log/slog"
-	"os"
-	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/username918r818/torrent-client/message"
 	"github.com/username918r818/torrent-client/util"
@@ -25,6 +25,26 @@ const (
 	Corrupted
 )
 
+func (s PieceState) String() string {
+	switch s {
+	case NotStarted:
+		return "not started"
+	case InProgress:
+		return "in progress"
+	case Downloaded:
+		return "downloaded"
+	case Validated:
+		return "validated"
+	case Saving:
+		return "saving"
+	case Saved:
+		return "saved"
+	case Corrupted:
+		return "corrupted"
+	}
+	return "unknown"
+}
+
 type Piece struct {
 	state      PieceState
 	data       []byte
@@ -38,10 +58,34 @@ type PieceArray struct {
 	pieceLength     int64
 	lastPieceLength int64
 	locks           []sync.Mutex
-	listTLock       sync.Mutex                   // locks for toSave
+	listTLock       sync.Mutex                   // locks for toSave and toSaveSince
 	toSave          *util.List[util.Pair[int64]] // used to know ranges of downloaded but not saved yet data
-	listSLock       sync.Mutex                   // locks for Saved
+	toSaveSince     time.Time                    // when the oldest data in toSave was added
+	toSaveCh        chan struct{}                // wakes up the flusher when toSave grows
+	saving          atomic.Int64                 // bytes taken from toSave and not reported saved yet
+	listSLock       sync.Mutex                   // locks for Saved and savedCh
 	Saved           *util.List[util.Pair[int64]] // used to know ranges of saved data
+	savedCh         chan struct{}                // closed and replaced when Saved grows
+	prioLock        sync.Mutex                   // locks for priority and fields below
+	priority        []FilePriority               // highest priority of files overlapping a piece
+	skippedFiles    []bool                       // by file index, their data goes to the part file
+	sequential      bool
+	readPiece       int
+	deadlines       []deadline // sorted by time
+	lastDeadline    DeadlineId
+	buffered        atomic.Int64 // bytes of download buffers and validated pieces not saved yet
+	peakBuffered    atomic.Int64
+	budget          atomic.Int64 // no new tasks are given while buffered is above it, 0 means no limit
+	throttled       atomic.Int64
+	received        atomic.Int64 // bytes of blocks received from peers
+}
+
+// BufferStats describes memory held by pieces that are not saved yet.
+type BufferStats struct {
+	Buffered  int64
+	Peak      int64
+	Budget    int64
+	Throttled int64 // times a task was not given because of the budget
 }
 
 func Validate(data []byte, hash [20]byte) bool {
@@ -60,9 +104,96 @@ func InitPieceArray(totalBytes, pieceLength int64) (a PieceArray) {
 	a.validPieces = make(map[int64][]byte)
 	a.locks = make([]sync.Mutex, arrLength)
 	a.pieceLength = pieceLength
+	a.savedCh = make(chan struct{})
+	a.toSaveCh = make(chan struct{}, 1)
+	a.priority = make([]FilePriority, arrLength)
+	for i := range a.priority {
+		a.priority[i] = PriorityNormal
+	}
 	return
 }
 
+// SetMemoryBudget limits memory used for pieces that are not saved yet. Above
+// the budget peers get no new tasks until file workers catch up, 0 means no limit.
+func (a *PieceArray) SetMemoryBudget(bytes int64) {
+	a.budget.Store(bytes)
+}
+
+// Received returns bytes of blocks received from peers since the torrent was
+// started, blocks received twice are counted twice.
+func (a *PieceArray) Received() int64 {
+	return a.received.Load()
+}
+
+func (a *PieceArray) BufferStats() BufferStats {
+	return BufferStats{a.buffered.Load(), a.peakBuffered.Load(), a.budget.Load(), a.throttled.Load()}
+}
+
+func (a *PieceArray) addBuffered(n int64) {
+	buffered := a.buffered.Add(n)
+	for peak := a.peakBuffered.Load(); buffered > peak; peak = a.peakBuffered.Load() {
+		if a.peakBuffered.CompareAndSwap(peak, buffered) {
+			break
+		}
+	}
+}
+
+// overBudget reports whether new tasks have to wait for buffered pieces to be saved.
+func (a *PieceArray) overBudget() bool {
+	budget := a.budget.Load()
+	if budget > 0 && a.buffered.Load() >= budget {
+		a.throttled.Add(1)
+		return true
+	}
+	return false
+}
+
+func (a *PieceArray) pieceBounds(pieceIndex int) (int64, int64) {
+	lw := int64(pieceIndex) * a.pieceLength
+	if pieceIndex == len(a.pieces)-1 {
+		return lw, lw + a.lastPieceLength
+	}
+	return lw, lw + a.pieceLength
+}
+
+// notifySaved wakes up everyone waiting for saved data, listSLock has to be held.
+func (a *PieceArray) notifySaved() {
+	close(a.savedCh)
+	a.savedCh = make(chan struct{})
+}
+
+// savedChan returns a channel that is closed once more data is saved.
+func (a *PieceArray) savedChan() <-chan struct{} {
+	a.listSLock.Lock()
+	defer a.listSLock.Unlock()
+	return a.savedCh
+}
+
+// WaitSaved blocks until bytes [from, to) are saved on disk.
+func (a *PieceArray) WaitSaved(ctx context.Context, from, to int64) error {
+	for {
+		a.listSLock.Lock()
+		saved := util.Contains(a.Saved, from, to)
+		savedCh := a.savedCh
+		a.listSLock.Unlock()
+		if saved {
+			return nil
+		}
+
+		select {
+		case <-savedCh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (a *PieceArray) IsSaved(from, to int64) bool {
+	a.listSLock.Lock()
+	defer a.listSLock.Unlock()
+	return util.Contains(a.Saved, from, to)
+}
+
 func UpdatePiece(pieceIndex int, a *PieceArray) ([]byte, error) {
 	a.locks[pieceIndex].Lock()
 	defer a.locks[pieceIndex].Unlock()
@@ -76,6 +207,7 @@ func UpdatePiece(pieceIndex int, a *PieceArray) ([]byte, error) {
 			newLength = a.lastPieceLength
 		}
 		a.pieces[pieceIndex].data = make([]byte, newLength)
+		a.addBuffered(newLength)
 		return a.pieces[pieceIndex].data, nil
 	}
 
@@ -88,12 +220,44 @@ func DeletePiece(pieceIndex int, a *PieceArray) {
 	if a.pieces[pieceIndex].state == InProgress {
 		a.pieces[pieceIndex].state = NotStarted
 	}
+	a.addBuffered(-int64(len(a.pieces[pieceIndex].data)))
 	a.pieces[pieceIndex].data = nil
 	a.pieces[pieceIndex].downloaded = nil
 
 }
 
-func StartPieceWorker(ctx context.Context, pieces *PieceArray, tf *TorrentFile, fileMap map[string]*os.File, ch message.PieceChannels) {
+// setChecked updates state of a piece which data on disk was hashed, returns
+// change of saved bytes. Pieces that are downloading at the moment are left as is.
+func (a *PieceArray) setChecked(pieceIndex int, valid bool) int64 {
+	lw, up := a.pieceBounds(pieceIndex)
+	a.locks[pieceIndex].Lock()
+	defer a.locks[pieceIndex].Unlock()
+	switch {
+	case valid && a.pieces[pieceIndex].state == NotStarted:
+		a.pieces[pieceIndex].state = Saved
+		a.listSLock.Lock()
+		a.Saved = util.InsertRange(a.Saved, lw, up)
+		a.notifySaved()
+		a.listSLock.Unlock()
+		return up - lw
+	case !valid && a.pieces[pieceIndex].state == Saved:
+		a.pieces[pieceIndex].state = NotStarted
+		a.listSLock.Lock()
+		a.Saved = util.RemoveRange(a.Saved, lw, up)
+		a.listSLock.Unlock()
+		return lw - up
+	}
+	return 0
+}
+
+func StartPieceWorker(ctx context.Context, pieces *PieceArray, tf *TorrentFile, ch message.PieceChannels) {
+	// the tracker worker may be stopped before piece workers
+	postStats := func(diff message.StatDiff) {
+		select {
+		case ch.PostStatsChannel <- diff:
+		case <-ctx.Done():
+		}
+	}
 
 	for {
 		select {
@@ -114,16 +278,16 @@ func StartPieceWorker(ctx context.Context, pieces *PieceArray, tf *TorrentFile,
 				pieceCopy := make([]byte, len(pieces.pieces[pieceIndex].data))
 				copy(pieceCopy, pieces.pieces[pieceIndex].data)
 				if Validate(pieceCopy, tf.Pieces[pieceIndex]) {
+					// the copy is kept until it is saved, the download buffer is not needed anymore
 					pieces.pieces[pieceIndex].state = Validated
+					pieces.pieces[pieceIndex].data = nil
 					pieces.locks[pieceIndex].Unlock()
-					pieces.listTLock.Lock()
-					pieces.toSave = util.InsertRange(pieces.toSave, pieceLowerBound, pieceUpperBound)
-					pieces.listTLock.Unlock()
 					pieces.validLock.Lock()
 					pieces.validPieces[pieceIndex] = pieceCopy
 					pieces.validLock.Unlock()
-					msg := message.StatDiff{Validated: pieceUpperBound - pieceLowerBound}
-					ch.PostStatsChannel <- msg
+					pieces.addToSave(pieceLowerBound, pieceUpperBound)
+					msg := message.StatDiff{NotStarted: pieceLowerBound - pieceUpperBound, Validated: pieceUpperBound - pieceLowerBound}
+					postStats(msg)
 
 				} else {
 					pieces.locks[pieceIndex].Unlock()
@@ -134,75 +298,6 @@ func StartPieceWorker(ctx context.Context, pieces *PieceArray, tf *TorrentFile,
 				pieces.locks[pieceIndex].Unlock()
 			}
 
-		case ready := (<-ch.FileWorkerReady):
-			slog.Info("Piece worker: received new ready")
-			if !ready {
-				break
-			}
-			pieces.listTLock.Lock()
-			firstRange := pieces.toSave
-			if pieces.toSave != nil {
-				pieces.toSave = pieces.toSave.Next
-			}
-			pieces.listTLock.Unlock()
-			if firstRange == nil {
-				msg := message.SaveRange{}
-				msg.Length = -1
-				ch.FileWorkerToSave <- msg
-				break
-			}
-			totalOffset := firstRange.Value.First
-			length := firstRange.Value.Second - firstRange.Value.First
-			var f *os.File
-			var fileStartOffset, fLength int64
-			var currentOffset int64
-			for _, curFile := range tf.Files {
-				if currentOffset+curFile.Length > totalOffset {
-					if len(curFile.Path) > 1 {
-						f = fileMap[strings.Join(curFile.Path, "/")]
-					} else {
-						f = fileMap[curFile.Path[0]]
-					}
-					fileStartOffset = currentOffset
-					fLength = curFile.Length
-					break
-				}
-				currentOffset += curFile.Length
-			}
-
-			fileOffsetInFile := totalOffset - fileStartOffset
-			remainingInFile := fLength - fileOffsetInFile
-			if length > remainingInFile {
-				length = remainingInFile
-				pieces.listTLock.Lock()
-				pieces.toSave = util.InsertRange(pieces.toSave, firstRange.Value.First+length, firstRange.Value.Second)
-				pieces.listTLock.Unlock()
-			}
-
-			firstPiece := totalOffset / pieces.pieceLength
-			lastPiece := (totalOffset + length - 1) / pieces.pieceLength
-
-			dataToSend := make([][]byte, lastPiece+1)
-			for i := firstPiece; i <= lastPiece; i++ {
-				pieces.validLock.Lock()
-				dataToSend[i] = pieces.validPieces[i]
-				pieces.validLock.Unlock()
-			}
-
-			msg := message.SaveRange{}
-			msg.InfoHash = tf.InfoHash
-			msg.Pieces = dataToSend
-			msg.PieceLength = pieces.pieceLength
-			msg.Offset = totalOffset
-			msg.FileOffset = fileOffsetInFile
-			msg.Length = length
-			msg.File = f
-			msg.Callback = ch.CallBack
-
-			msgStats := message.StatDiff{Saving: length}
-			ch.PostStatsChannel <- msgStats
-			ch.FileWorkerToSave <- msg
-
 		case isSaved, ok := (<-ch.FileWorkerIsSaved):
 			slog.Info("Piece worker: received new saved")
 			if !ok {
@@ -210,19 +305,22 @@ func StartPieceWorker(ctx context.Context, pieces *PieceArray, tf *TorrentFile,
 			}
 			msgStats := message.StatDiff{Saving: -isSaved.Length}
 			if !isSaved.IsSaved {
-				ch.PostStatsChannel <- msgStats
-				pieces.listTLock.Lock()
-				pieces.toSave = util.InsertRange(pieces.toSave, isSaved.Offset, isSaved.Offset+isSaved.Length)
-				pieces.listTLock.Unlock()
+				msgStats[Validated] += isSaved.Length
+				postStats(msgStats)
+				// the range goes back to the cache before it stops being in flight
+				pieces.addToSave(isSaved.Offset, isSaved.Offset+isSaved.Length)
+				pieces.saving.Add(-isSaved.Length)
 				break
 			}
 
 			msgStats[Saved] += isSaved.Length
-			ch.PostStatsChannel <- msgStats
+			postStats(msgStats)
 
 			pieces.listSLock.Lock()
 			pieces.Saved = util.InsertRange(pieces.Saved, isSaved.Offset, isSaved.Offset+isSaved.Length)
+			pieces.notifySaved()
 			pieces.listSLock.Unlock()
+			pieces.saving.Add(-isSaved.Length)
 
 			firstPiece := isSaved.Offset / pieces.pieceLength
 			lastPiece := (isSaved.Offset + isSaved.Length - 1) / pieces.pieceLength
@@ -243,6 +341,7 @@ func StartPieceWorker(ctx context.Context, pieces *PieceArray, tf *TorrentFile,
 				pieces.pieces[i].state = Saved
 				pieces.pieces[i].data = nil
 				pieces.validLock.Lock()
+				pieces.addBuffered(-int64(len(pieces.validPieces[i])))
 				delete(pieces.validPieces, i)
 				pieces.validLock.Unlock()
 			}
@@ -0,0 +1,40 @@
+package torrent
+
+import (
+	"testing"
+
+	"github.com/username918r818/torrent-client/message"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	a := InitPieceArray(12, 4)
+	a.SetMemoryBudget(8)
+	pick := func() error {
+		_, err := findTask(&a, []byte{0xff}, 1, make(map[int][6]byte), make(map[[6]byte]message.DownloadRange), [6]byte{1})
+		return err
+	}
+
+	if _, err := UpdatePiece(0, &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := pick(); err != nil {
+		t.Fatalf("expected task under the budget, got %v", err)
+	}
+
+	if _, err := UpdatePiece(1, &a); err != nil {
+		t.Fatal(err)
+	}
+	if err := pick(); err == nil {
+		t.Fatal("expected no task above the budget")
+	}
+
+	DeletePiece(1, &a)
+	if err := pick(); err != nil {
+		t.Fatalf("expected task once memory is freed, got %v", err)
+	}
+
+	stats := a.BufferStats()
+	if stats.Buffered != 4 || stats.Peak != 8 || stats.Budget != 8 || stats.Throttled != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,140 @@
+package torrent
+
+import (
+	"encoding/hex"
+	"path/filepath"
+
+	"github.com/username918r818/torrent-client/file"
+)
+
+type FilePriority int
+
+const (
+	PrioritySkip FilePriority = iota
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+)
+
+const (
+	PartSuffix = ".parts"
+)
+
+// PartPath is a file keeping data of skipped files that share pieces with
+// wanted ones. It is written at torrent offsets, so it stays sparse.
+func PartPath(infoHash [20]byte) string {
+	return hex.EncodeToString(infoHash[:]) + PartSuffix
+}
+
+// setFilePriorities gives every piece the highest priority of files it overlaps.
+func (a *PieceArray) setFilePriorities(tf *TorrentFile, priorities []FilePriority) {
+	a.prioLock.Lock()
+	defer a.prioLock.Unlock()
+	for i := range a.priority {
+		a.priority[i] = PrioritySkip
+	}
+
+	a.skippedFiles = make([]bool, len(tf.Files))
+	var fileStart int64
+	for i, f := range tf.Files {
+		a.skippedFiles[i] = priorities[i] == PrioritySkip
+		fileEnd := fileStart + f.Length
+		if f.Length > 0 {
+			first, last := int(fileStart/a.pieceLength), int((fileEnd-1)/a.pieceLength)
+			for j := first; j <= last && j < len(a.priority); j++ {
+				a.priority[j] = max(a.priority[j], priorities[i])
+			}
+		}
+		fileStart = fileEnd
+	}
+}
+
+// skippedBytes counts bytes of pieces that are not downloaded at all.
+func (a *PieceArray) skippedBytes() int64 {
+	a.prioLock.Lock()
+	defer a.prioLock.Unlock()
+	var skipped int64
+	for i, prio := range a.priority {
+		if prio == PrioritySkip {
+			lw, up := a.pieceBounds(i)
+			skipped += up - lw
+		}
+	}
+	return skipped
+}
+
+// hasSkipped reports whether bytes [from, to) touch pieces that are not downloaded at all.
+func (a *PieceArray) hasSkipped(from, to int64) bool {
+	a.prioLock.Lock()
+	defer a.prioLock.Unlock()
+	for i := int(from / a.pieceLength); i <= int((to-1)/a.pieceLength) && i < len(a.priority); i++ {
+		if a.priority[i] == PrioritySkip {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *PieceArray) isFileSkipped(index int) bool {
+	a.prioLock.Lock()
+	defer a.prioLock.Unlock()
+	return index < len(a.skippedFiles) && a.skippedFiles[index]
+}
+
+// diskStorage is a storage of files on disk, like file.Disk and file.Mmap.
+type diskStorage interface {
+	file.Storage
+	AddPath(key file.Key, path string)
+}
+
+// allocFiles creates wanted files of the torrent on disk and adds them to
+// storage, skipped files are not created at all. If something is skipped, the
+// part file is created too. Files are opened by storage when they are used.
+func allocFiles(tf *TorrentFile, priorities []FilePriority, mode file.AllocMode, storage diskStorage) error {
+	var wanted []struct {
+		Length int64
+		Path   []string
+	}
+	var indexes []int
+	for i, f := range tf.Files {
+		if priorities[i] != PrioritySkip {
+			wanted = append(wanted, f)
+			indexes = append(indexes, i)
+		}
+	}
+
+	if err := file.Alloc(wanted, mode); err != nil {
+		return err
+	}
+
+	for j, f := range wanted {
+		storage.AddPath(file.Key{InfoHash: tf.InfoHash, Index: indexes[j]}, filepath.Join(f.Path...))
+	}
+
+	if len(wanted) < len(tf.Files) {
+		if err := file.CreatePart(PartPath(tf.InfoHash)); err != nil {
+			return err
+		}
+		storage.AddPath(file.Key{InfoHash: tf.InfoHash, Index: file.PartIndex}, PartPath(tf.InfoHash))
+	}
+	return nil
+}
+
+// locateRange finds the file where data of the torrent starting from offset is
+// written, and how many bytes of length fit into it. Data of skipped files goes
+// to the part file at torrent offsets.
+func locateRange(tf *TorrentFile, a *PieceArray, offset, length int64) (file.Key, int64, int64) {
+	var fileStart int64
+	for i, f := range tf.Files {
+		fileEnd := fileStart + f.Length
+		if fileEnd > offset {
+			length = min(length, fileEnd-offset)
+			if a.isFileSkipped(i) {
+				return file.Key{InfoHash: tf.InfoHash, Index: file.PartIndex}, offset, length
+			}
+			return file.Key{InfoHash: tf.InfoHash, Index: i}, offset - fileStart, length
+		}
+		fileStart = fileEnd
+	}
+	return file.Key{}, 0, 0
+}
@@ -0,0 +1,112 @@
+package torrent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/username918r818/torrent-client/file"
+	"github.com/username918r818/torrent-client/message"
+)
+
+// newPriorityTorrent has three pieces of 4 bytes over files of 6, 2 and 2 bytes:
+// the second piece is shared by the first two.
+func newPriorityTorrent(dir string) *Handle {
+	tf := TorrentFile{PieceLength: 4, Pieces: make([][20]byte, 3)}
+	tf.InfoHash[0] = 2
+	tf.Files = []struct {
+		Length int64
+		Path   []string
+	}{
+		{Length: 6, Path: []string{dir, "a.txt"}},
+		{Length: 2, Path: []string{dir, "b.txt"}},
+		{Length: 2, Path: []string{dir, "c.txt"}},
+	}
+	return NewHandle(tf)
+}
+
+func TestFindTaskByPriority(t *testing.T) {
+	h := newPriorityTorrent(t.TempDir())
+	bitfield := []byte{0xff}
+	peer := [6]byte{1}
+
+	pick := func() int64 {
+		task, err := findTask(h.pieces, bitfield, 1, make(map[int][6]byte), make(map[[6]byte]message.DownloadRange), peer)
+		if err != nil {
+			t.Fatalf("expected task, got %v", err)
+		}
+		return task.Offset / 4
+	}
+
+	if got := pick(); got != 0 {
+		t.Fatalf("expected first piece by default, got %d", got)
+	}
+
+	if err := h.SetFilePriority(1, PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+	if got := pick(); got != 1 {
+		t.Fatalf("expected boundary piece of the high priority file, got %d", got)
+	}
+
+	if err := h.SetFilePriority(0, PrioritySkip); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetFilePriority(1, PrioritySkip); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetFilePriority(2, PrioritySkip); err != nil {
+		t.Fatal(err)
+	}
+	_, err := findTask(h.pieces, bitfield, 1, make(map[int][6]byte), make(map[[6]byte]message.DownloadRange), peer)
+	if err == nil {
+		t.Fatal("expected no task when everything is skipped")
+	}
+}
+
+func TestSkippedFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Chdir(tempDir)
+	h := newPriorityTorrent(tempDir)
+	if err := h.SetFilePriority(1, PrioritySkip); err != nil {
+		t.Fatal(err)
+	}
+
+	disk := file.NewDisk()
+	if err := allocFiles(&h.File, h.FilePriorities(), file.AllocSparse, disk); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "b.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected skipped file not to be created, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "c.txt")); err != nil {
+		t.Fatalf("expected wanted file to be created, got %v", err)
+	}
+
+	// second piece [4, 8): 2 bytes of a.txt, then b.txt that is skipped
+	key, fileOffset, length := locateRange(&h.File, h.pieces, 4, 4)
+	if key.Index != 0 || fileOffset != 4 || length != 2 {
+		t.Fatalf("unexpected location of a.txt part: %d, %d, %d", key.Index, fileOffset, length)
+	}
+
+	key, fileOffset, length = locateRange(&h.File, h.pieces, 6, 2)
+	if key.Index != file.PartIndex || fileOffset != 6 || length != 2 {
+		t.Fatalf("expected skipped part to go to the part file at torrent offset, got %d, %d, %d", key.Index, fileOffset, length)
+	}
+	if _, err := disk.WriteAt(key, []byte("67"), fileOffset); err != nil {
+		t.Fatal(err)
+	}
+
+	part, err := os.ReadFile(filepath.Join(tempDir, PartPath(h.File.InfoHash)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(part) != 8 || string(part[6:]) != "67" {
+		t.Fatalf("unexpected part file content: %q", part)
+	}
+
+	if skipped := h.pieces.skippedBytes(); skipped != 0 {
+		t.Fatalf("expected boundary piece not to be skipped, got %d skipped bytes", skipped)
+	}
+}
@@ -0,0 +1,49 @@
+package torrent_test
+
+import (
+	"testing"
+
+	"github.com/username918r818/torrent-client/torrent"
+)
+
+func TestFilePriorities(t *testing.T) {
+	tf := torrent.TorrentFile{PieceLength: 4, Pieces: make([][20]byte, 3)}
+	tf.Files = []struct {
+		Length int64
+		Path   []string
+	}{
+		{Length: 6, Path: []string{"a.txt"}},
+		{Length: 4, Path: []string{"b.txt"}},
+	}
+	h := torrent.NewHandle(tf)
+
+	t.Run("normal by default", func(t *testing.T) {
+		for i, p := range h.FilePriorities() {
+			if p != torrent.PriorityNormal {
+				t.Fatalf("expected normal priority of file %d, got %v", i, p)
+			}
+		}
+	})
+
+	t.Run("set priority", func(t *testing.T) {
+		if err := h.SetFilePriority(1, torrent.PrioritySkip); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := h.SetFilePriority(0, torrent.PriorityHigh); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		p := h.FilePriorities()
+		if p[0] != torrent.PriorityHigh || p[1] != torrent.PrioritySkip {
+			t.Fatalf("unexpected priorities: %v", p)
+		}
+	})
+
+	t.Run("wrong arguments", func(t *testing.T) {
+		if err := h.SetFilePriority(2, torrent.PriorityLow); err == nil {
+			t.Fatal("expected error, but got none")
+		}
+		if err := h.SetFilePriority(0, torrent.PriorityHigh+1); err == nil {
+			t.Fatal("expected error, but got none")
+		}
+	})
+}
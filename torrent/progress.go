@@ -0,0 +1,74 @@
+package torrent
+
+// Progress is a snapshot of a torrent for display.
+type Progress struct {
+	State      TorrentState
+	Wanted     int64 // bytes of files that are not skipped
+	Saved      int64 // wanted bytes saved to disk
+	Received   int64 // bytes of blocks received from peers
+	Uploaded   int64
+	Peers      int // connected now
+	KnownPeers int
+	Pieces     [Corrupted + 1]int // number of pieces in every state
+	Files      []FileProgress
+}
+
+// FileProgress is progress of a file of the torrent.
+type FileProgress struct {
+	Path    []string
+	Length  int64
+	Saved   int64
+	Skipped bool
+}
+
+// Progress takes a snapshot of the torrent, it may be called at any time.
+func (h *Handle) Progress() Progress {
+	a := h.pieces
+	p := Progress{
+		State:      h.State(),
+		Received:   a.Received(),
+		Uploaded:   h.Transfer().Uploaded,
+		Peers:      int(h.peers.Load()),
+		KnownPeers: int(h.knownPeers.Load()),
+	}
+
+	for i := range a.pieces {
+		a.locks[i].Lock()
+		p.Pieces[a.pieces[i].state]++
+		a.locks[i].Unlock()
+	}
+
+	priorities := h.FilePriorities()
+	p.Files = make([]FileProgress, len(h.File.Files))
+	var offset int64
+	for i, f := range h.File.Files {
+		fp := FileProgress{Path: f.Path, Length: f.Length, Skipped: priorities[i] == PrioritySkip}
+		fp.Saved = a.savedIn(offset, offset+f.Length)
+		if !fp.Skipped {
+			p.Wanted += fp.Length
+			p.Saved += fp.Saved
+		}
+		p.Files[i] = fp
+		offset += f.Length
+	}
+	return p
+}
+
+func (h *Handle) setPeers(connected, known int) {
+	h.peers.Store(int64(connected))
+	h.knownPeers.Store(int64(known))
+}
+
+// savedIn returns how many bytes from from to to are saved.
+func (a *PieceArray) savedIn(from, to int64) int64 {
+	a.listSLock.Lock()
+	defer a.listSLock.Unlock()
+	var n int64
+	for node := a.Saved; node != nil; node = node.Next {
+		lw, up := max(node.Value.First, from), min(node.Value.Second, to)
+		if up > lw {
+			n += up - lw
+		}
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package torrent_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/username918r818/torrent-client/torrent"
+	"github.com/username918r818/torrent-client/torrent/torrenttest"
+)
+
+func TestProgress(t *testing.T) {
+	t.Chdir(t.TempDir())
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer tracker.Close()
+
+	tf := torrenttest.NewTorrent("dir", "a.txt", "b.txt")
+	tf.Announce = tracker.URL
+	if err := torrenttest.WriteFiles(&tf, torrenttest.Data[:6], torrenttest.Data[6:]); err != nil {
+		t.Fatal(err)
+	}
+
+	// skipped files are not wanted
+	skipped := torrent.NewHandle(tf)
+	if err := skipped.SetFilePriority(1, torrent.PrioritySkip); err != nil {
+		t.Fatal(err)
+	}
+	p := skipped.Progress()
+	if p.Wanted != 6 || p.Saved != 0 || p.Pieces[torrent.NotStarted] != 3 || !p.Files[1].Skipped {
+		t.Fatalf("unexpected progress before start: %+v", p)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := torrent.NewSession(ctx, 6881, torrent.DefaultConfig())
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+	h := torrent.NewHandle(tf)
+	if err := s.Add(h); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, h, torrent.StateSeeding)
+
+	p = h.Progress()
+	if p.State != torrent.StateSeeding || p.Wanted != 10 || p.Saved != 10 {
+		t.Fatalf("unexpected progress: %+v", p)
+	}
+	if p.Pieces[torrent.Saved] != 3 || p.Files[0].Saved != 6 || p.Files[1].Saved != 4 {
+		t.Fatalf("unexpected pieces or files: %+v", p)
+	}
+}
@@ -0,0 +1,140 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// queueLimits are limits of active torrents of a session.
+type queueLimits struct {
+	downloads, seeds int           // no limit if zero
+	stallTime        time.Duration // downloads without data for that long take no slot, never if zero
+}
+
+// SetQueueLimits limits how many torrents download and seed at once, zero
+// means no limit. Torrents above the limits are queued in order of List:
+// the queue pauses them and resumes them once earlier torrents free a slot.
+func (s *Session) SetQueueLimits(downloads, seeds int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue.downloads, s.queue.seeds = downloads, seeds
+	s.wakeQueue()
+}
+
+// SetStallTime makes downloads that received no data for d stalled, stalled
+// torrents keep running but don't take a slot of the queue, so the next
+// queued torrent is started. Zero turns it off.
+func (s *Session) SetStallTime(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue.stallTime = d
+	s.wakeQueue()
+}
+
+// Stalled reports whether the torrent is a stalled download.
+func (s *Session) Stalled(infoHash [20]byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.find(infoHash); i >= 0 {
+		return s.torrents[i].stalled
+	}
+	return false
+}
+
+// Move moves the torrent to pos in the queue, positions past the end move it
+// to the end.
+func (s *Session) Move(infoHash [20]byte, pos int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(infoHash)
+	if i < 0 {
+		return errors.New("session: torrent is not added")
+	}
+	t := s.torrents[i]
+	s.torrents = slices.Delete(s.torrents, i, i+1)
+	pos = min(max(pos, 0), len(s.torrents))
+	s.torrents = slices.Insert(s.torrents, pos, t)
+	s.wakeQueue()
+	return nil
+}
+
+func (s *Session) wakeQueue() {
+	select {
+	case s.queueWake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Session) runQueue(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.QueueInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.queueWake:
+		case <-ctx.Done():
+			return
+		}
+		s.updateQueue(time.Now())
+	}
+}
+
+// updateQueue queues torrents above the limits and resumes queued ones that
+// got a slot. Torrents that are starting, checked or paused by the user are
+// left as they are and take no slot.
+func (s *Session) updateQueue(now time.Time) {
+	type change struct {
+		t   *sessionTorrent
+		cmd int
+	}
+	var changes []change
+
+	s.mu.Lock()
+	downloads, seeds := 0, 0
+	for _, t := range s.torrents {
+		state := t.h.State()
+		switch state {
+		case StateDownloading, StateSeeding:
+			t.seeding = state == StateSeeding
+		case StateQueued:
+		default:
+			continue
+		}
+
+		// only running downloads may stall, the others start again from now
+		received := t.h.pieces.Received()
+		if received != t.received || state != StateDownloading {
+			t.received, t.progress = received, now
+		}
+		t.stalled = s.queue.stallTime > 0 && state == StateDownloading && now.Sub(t.progress) >= s.queue.stallTime
+		if t.stalled {
+			continue
+		}
+
+		used, limit := &downloads, s.queue.downloads
+		if t.seeding {
+			used, limit = &seeds, s.queue.seeds
+		}
+		active := limit == 0 || *used < limit
+		if active {
+			*used++
+		}
+		if active && state == StateQueued {
+			changes = append(changes, change{t, CommandResume})
+		}
+		if !active && state != StateQueued {
+			changes = append(changes, change{t, CommandQueue})
+		}
+	}
+	s.mu.Unlock()
+
+	// commands wait for supervisors, the lock is not held meanwhile
+	for _, c := range changes {
+		if err := c.t.h.command(c.t.ctx, c.cmd); err != nil {
+			slog.Info("Queue: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package torrent_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/torrent"
+	"github.com/username918r818/torrent-client/torrent/torrenttest"
+)
+
+func TestQueue(t *testing.T) {
+	t.Chdir(t.TempDir())
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer tracker.Close()
+
+	cfg := torrent.DefaultConfig()
+	cfg.QueueInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	s := torrent.NewSession(ctx, 6881, cfg)
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+	s.SetQueueLimits(1, 1)
+
+	// first three torrents are complete on disk, the last two have no data and no peers
+	var handles []*torrent.Handle
+	for i, dir := range []string{"s1", "s2", "s3", "d1", "d2"} {
+		tf := torrenttest.NewTorrent(dir, "a.txt", "b.txt")
+		tf.Announce = tracker.URL
+		tf.InfoHash[0] = byte(i + 1)
+		if i < 3 {
+			if err := torrenttest.WriteFiles(&tf, torrenttest.Data[:6], torrenttest.Data[6:]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		h := torrent.NewHandle(tf)
+		if err := s.Add(h); err != nil {
+			t.Fatal(err)
+		}
+		handles = append(handles, h)
+	}
+
+	t.Run("limits", func(t *testing.T) {
+		waitState(t, handles[0], torrent.StateSeeding)
+		waitState(t, handles[1], torrent.StateQueued)
+		waitState(t, handles[2], torrent.StateQueued)
+		waitState(t, handles[3], torrent.StateDownloading)
+		waitState(t, handles[4], torrent.StateQueued)
+	})
+
+	t.Run("move", func(t *testing.T) {
+		if err := s.Move(handles[2].File.InfoHash, 0); err != nil {
+			t.Fatal(err)
+		}
+		if list := s.List(); list[0] != handles[2] || list[1] != handles[0] {
+			t.Fatalf("expected the third torrent first, got %v", list)
+		}
+		waitState(t, handles[2], torrent.StateSeeding)
+		waitState(t, handles[0], torrent.StateQueued)
+	})
+
+	t.Run("paused by user", func(t *testing.T) {
+		if err := handles[2].Pause(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// a paused torrent takes no slot and is not resumed by the queue
+		waitState(t, handles[0], torrent.StateSeeding)
+		time.Sleep(5 * cfg.QueueInterval)
+		if handles[2].State() != torrent.StatePaused {
+			t.Fatalf("expected paused torrent, got %v", handles[2].State())
+		}
+	})
+
+	t.Run("stalled", func(t *testing.T) {
+		s.SetStallTime(50 * time.Millisecond)
+		waitState(t, handles[4], torrent.StateDownloading)
+		if !s.Stalled(handles[3].File.InfoHash) || handles[3].State() != torrent.StateDownloading {
+			t.Fatalf("expected the first download to keep running stalled, got %v", handles[3].State())
+		}
+	})
+}
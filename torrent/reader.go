@@ -0,0 +1,158 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/username918r818/torrent-client/file"
+)
+
+const (
+	ReadaheadDeadline = 5 * time.Second // deadline of the window after the bytes being read
+)
+
+var ErrSkipped = errors.New("reader: range is in skipped files")
+
+// Reader reads payload of a running torrent or a single file of it. Reads
+// block until requested bytes are saved and raise priority of their pieces.
+type Reader struct {
+	ctx     context.Context
+	tf      *TorrentFile
+	pieces  *PieceArray
+	storage file.Storage
+	cache   *ReadCache // pieces are read from storage directly if nil
+	offset  int64      // start of the reader in the torrent
+	length  int64
+	pos     int64
+
+	mu        sync.Mutex // guards deadlines
+	deadlines []DeadlineId
+}
+
+// NewReader reads length bytes of the torrent starting from offset. Blocked
+// reads return when ctx is done, deadlines of the reader are removed then.
+func NewReader(ctx context.Context, tf *TorrentFile, a *PieceArray, storage file.Storage, offset, length int64) *Reader {
+	r := &Reader{ctx: ctx, tf: tf, pieces: a, storage: storage, offset: offset, length: length}
+	context.AfterFunc(ctx, r.clearDeadlines)
+	return r
+}
+
+// setDeadlines replaces deadlines of the previous read with ones for bytes
+// [from, to) and the window after them.
+func (r *Reader) setDeadlines(from, to int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range r.deadlines {
+		r.pieces.RemoveDeadline(id)
+	}
+	r.deadlines = r.deadlines[:0]
+	if r.ctx.Err() != nil {
+		// clearDeadlines has run or is about to
+		return
+	}
+	now := time.Now()
+	r.deadlines = append(r.deadlines,
+		r.pieces.SetDeadline(from, to, now),
+		r.pieces.SetDeadline(to, min(to+StreamWindow, r.offset+r.length), now.Add(ReadaheadDeadline)))
+}
+
+func (r *Reader) clearDeadlines() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range r.deadlines {
+		r.pieces.RemoveDeadline(id)
+	}
+	r.deadlines = nil
+}
+
+func (r *Reader) Size() int64 {
+	return r.length
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("reader: negative offset")
+	}
+	if off >= r.length {
+		return 0, io.EOF
+	}
+
+	n := min(int64(len(p)), r.length-off)
+	from, to := r.offset+off, r.offset+off+n
+
+	saved := r.pieces.IsSaved(from, to)
+	if !saved && r.pieces.hasSkipped(from, to) {
+		return 0, ErrSkipped
+	}
+	r.pieces.SetReadPosition(from)
+	r.setDeadlines(from, to)
+	if !saved {
+		if err := r.pieces.WaitSaved(r.ctx, from, to); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := r.read(from, p[:n]); err != nil {
+		return 0, err
+	}
+	if n < int64(len(p)) {
+		return int(n), io.EOF
+	}
+	return int(n), nil
+}
+
+// read reads saved bytes of the torrent starting from offset, through the
+// cache if the reader has one.
+func (r *Reader) read(offset int64, buf []byte) error {
+	if r.cache == nil {
+		return readRange(r.tf, r.pieces, r.storage, offset, buf)
+	}
+	for len(buf) > 0 {
+		index := int(offset / r.pieces.pieceLength)
+		begin := offset % r.pieces.pieceLength
+		n := min(int64(len(buf)), r.pieces.pieceLength-begin)
+		if err := r.cache.ReadBlock(r.tf, r.pieces, r.storage, index, begin, buf[:n]); err != nil {
+			return err
+		}
+		buf = buf[n:]
+		offset += n
+	}
+	return nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.pos >= r.length {
+		return 0, io.EOF
+	}
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.length
+	default:
+		return 0, errors.New("reader: wrong whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("reader: negative position")
+	}
+	r.pos = offset
+	// deadlines of the old position are not needed anymore
+	r.clearDeadlines()
+	if offset < r.length {
+		r.pieces.SetReadPosition(r.offset + offset)
+	}
+	return offset, nil
+}
@@ -0,0 +1,67 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// waitDeadlines waits until a has n deadlines and returns them.
+func waitDeadlines(t *testing.T, a *PieceArray, n int) []deadline {
+	t.Helper()
+	for range 100 {
+		a.prioLock.Lock()
+		deadlines := append([]deadline(nil), a.deadlines...)
+		a.prioLock.Unlock()
+		if len(deadlines) == n {
+			return deadlines
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d deadlines", n)
+	return nil
+}
+
+func TestReaderDeadlines(t *testing.T) {
+	a, tf := newStreamArray()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := NewReader(ctx, tf, a, nil, 0, 8*a.pieceLength)
+
+	read := func(off int64) {
+		go r.ReadAt(make([]byte, 1), off)
+	}
+
+	read(0)
+	if d := waitDeadlines(t, a, 2); d[0].first != 0 {
+		t.Fatalf("expected deadline of the first piece, got %v", d)
+	}
+
+	// deadlines of the previous read are replaced
+	read(3 * a.pieceLength)
+	time.Sleep(20 * time.Millisecond)
+	if d := waitDeadlines(t, a, 2); d[0].first != 3 || d[1].first != 3 {
+		t.Fatalf("expected deadlines from the fourth piece, got %v", d)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	waitDeadlines(t, a, 0)
+
+	read(a.pieceLength)
+	waitDeadlines(t, a, 2)
+	cancel()
+	waitDeadlines(t, a, 0)
+}
+
+func TestReaderSkipped(t *testing.T) {
+	a, tf := newStreamArray(PriorityNormal, PrioritySkip)
+	r := NewReader(context.Background(), tf, a, nil, 0, 8*a.pieceLength)
+
+	if _, err := r.ReadAt(make([]byte, 1), 7*a.pieceLength); !errors.Is(err, ErrSkipped) {
+		t.Fatalf("expected skipped error, got %v", err)
+	}
+}
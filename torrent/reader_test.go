@@ -0,0 +1,89 @@
+package torrent_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/torrent"
+	"github.com/username918r818/torrent-client/torrent/torrenttest"
+)
+
+func TestReader(t *testing.T) {
+	tempDir := t.TempDir()
+	data := []byte(torrenttest.Data)
+	tf := torrenttest.NewTorrent(tempDir, "a.txt", "b.txt")
+
+	if err := torrenttest.WriteFiles(&tf, torrenttest.Data[:6], "XXXX"); err != nil {
+		t.Fatal(err)
+	}
+	storage, err := torrenttest.OpenFiles(&tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pathB := filepath.Join(tempDir, "b.txt")
+
+	a := torrent.InitPieceArray(int64(len(data)), tf.PieceLength)
+	if _, err := torrent.Recheck(context.Background(), &tf, &a, storage, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("saved data", func(t *testing.T) {
+		r := torrent.NewReader(context.Background(), &tf, &a, storage, 0, 6)
+		buf := make([]byte, 4)
+		n, err := r.Read(buf)
+		if err != nil || n != 4 || string(buf) != "0123" {
+			t.Fatalf("expected 0123, got %q, %v", buf[:n], err)
+		}
+	})
+
+	t.Run("blocked until canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		r := torrent.NewReader(ctx, &tf, &a, storage, 0, 10)
+		if _, err := r.Seek(-2, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 2)
+		if _, err := r.Read(buf); err != context.DeadlineExceeded {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+	})
+
+	t.Run("blocked until saved", func(t *testing.T) {
+		r := torrent.NewReader(context.Background(), &tf, &a, storage, 6, 4)
+		done := make(chan []byte)
+		go func() {
+			b, err := io.ReadAll(r)
+			if err != nil {
+				t.Error(err)
+			}
+			done <- b
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("expected read to block")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		if err := os.WriteFile(pathB, data[6:], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := torrent.Recheck(context.Background(), &tf, &a, storage, 1, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case b := <-done:
+			if string(b) != "6789" {
+				t.Fatalf("expected 6789, got %q", b)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("read is still blocked")
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/username918r818/torrent-client/file"
+)
+
+type RecheckProgress struct {
+	Checked int
+	Valid   int
+	Total   int
+}
+
+// readRange reads data of the torrent starting from offset, it may span several files.
+func readRange(tf *TorrentFile, a *PieceArray, storage file.Storage, offset int64, buf []byte) error {
+	for len(buf) > 0 {
+		key, fileOffset, n := locateRange(tf, a, offset, int64(len(buf)))
+		if n == 0 {
+			return errors.New("recheck: range is out of torrent")
+		}
+		if _, err := storage.ReadAt(key, buf[:n], fileOffset); err != nil {
+			return fmt.Errorf("recheck: %w", err)
+		}
+		buf = buf[n:]
+		offset += n
+	}
+	return nil
+}
+
+// Recheck hashes pieces found in files against tf.Pieces in parallel. Valid
+// pieces are marked Saved, saved pieces with wrong data are downloaded again.
+// Returns change of saved bytes.
+func Recheck(ctx context.Context, tf *TorrentFile, a *PieceArray, storage file.Storage, workers int, progress func(RecheckProgress)) (int64, error) {
+	type result struct {
+		valid bool
+		diff  int64
+	}
+
+	indexes := make(chan int)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Go(func() {
+			buf := make([]byte, a.pieceLength)
+			for i := range indexes {
+				lw, up := a.pieceBounds(i)
+				data := buf[:up-lw]
+				valid := readRange(tf, a, storage, lw, data) == nil && Validate(data, tf.Pieces[i])
+				results <- result{valid, a.setChecked(i, valid)}
+			}
+		})
+	}
+
+	go func() {
+		defer close(indexes)
+		for i := range a.pieces {
+			a.locks[i].Lock()
+			state := a.pieces[i].state
+			a.locks[i].Unlock()
+			if state != NotStarted && state != Saved {
+				continue
+			}
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var diff int64
+	p := RecheckProgress{Total: len(a.pieces)}
+	for r := range results {
+		diff += r.diff
+		p.Checked++
+		if r.valid {
+			p.Valid++
+		}
+		if progress != nil && p.Checked%64 == 0 {
+			progress(p)
+		}
+	}
+	if progress != nil {
+		progress(p)
+	}
+
+	return diff, ctx.Err()
+}
@@ -0,0 +1,70 @@
+package torrent_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/username918r818/torrent-client/file"
+	"github.com/username918r818/torrent-client/torrent"
+	"github.com/username918r818/torrent-client/torrent/torrenttest"
+	"github.com/username918r818/torrent-client/util"
+)
+
+func TestRecheck(t *testing.T) {
+	tempDir := t.TempDir()
+	tf := torrenttest.NewTorrent(tempDir, "a.txt", "b.txt")
+
+	// second piece spans both files, last byte of it is corrupted
+	if err := torrenttest.WriteFiles(&tf, torrenttest.Data[:6], "X789"); err != nil {
+		t.Fatal(err)
+	}
+	storage, err := torrenttest.OpenFiles(&tf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := torrent.InitPieceArray(int64(len(torrenttest.Data)), tf.PieceLength)
+	var last torrent.RecheckProgress
+	restored, err := torrent.Recheck(context.Background(), &tf, &a, storage, 2, func(p torrent.RecheckProgress) { last = p })
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if restored != 6 {
+		t.Fatalf("expected 6 valid bytes, got %d", restored)
+	}
+	if !util.Contains(a.Saved, 0, 4) || !util.Contains(a.Saved, 8, 10) || util.Contains(a.Saved, 4, 8) {
+		t.Fatalf("unexpected saved ranges")
+	}
+	if last.Checked != 3 || last.Valid != 2 || last.Total != 3 {
+		t.Fatalf("unexpected progress: %+v", last)
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if err := storage.Close(file.Key{InfoHash: tf.InfoHash, Index: 0}); err != nil {
+			t.Fatal(err)
+		}
+		restored, err := torrent.Recheck(context.Background(), &tf, &a, storage, 2, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if restored != -4 {
+			t.Fatalf("expected first piece to be invalidated, got %d", restored)
+		}
+	})
+
+	t.Run("memory storage", func(t *testing.T) {
+		memory := file.NewMemory()
+		memory.WriteAt(file.Key{InfoHash: tf.InfoHash, Index: 0}, []byte(torrenttest.Data[:6]), 0)
+		memory.WriteAt(file.Key{InfoHash: tf.InfoHash, Index: 1}, []byte(torrenttest.Data[6:]), 0)
+
+		a := torrent.InitPieceArray(int64(len(torrenttest.Data)), tf.PieceLength)
+		restored, err := torrent.Recheck(context.Background(), &tf, &a, memory, 2, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if restored != 10 {
+			t.Fatalf("expected all data to be valid, got %d", restored)
+		}
+	})
+}
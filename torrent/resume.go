@@ -0,0 +1,262 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/username918r818/torrent-client/util"
+)
+
+const (
+	ResumeSuffix   = ".resume"
+	ResumeInterval = time.Minute
+)
+
+type ResumeFile struct {
+	Length int64 // -1 for skipped files
+	MTime  int64 // unix nanoseconds
+}
+
+// ResumeData is the state of a torrent that survives restarts.
+type ResumeData struct {
+	InfoHash    [20]byte
+	Saved       []util.Pair[int64]
+	Pieces      []PieceState
+	Files       []ResumeFile
+	Skipped     []int // indexes of skipped files, their data of boundary pieces is in the part file
+	Uploaded    int64
+	Downloaded  int64
+	SeedingTime time.Duration // in whole seconds
+}
+
+func ResumePath(infoHash [20]byte) string {
+	return hex.EncodeToString(infoHash[:]) + ResumeSuffix
+}
+
+// NewResumeData takes a snapshot of saved data. Saved ranges are read before
+// file times, so data written in between is just downloaded once more.
+func NewResumeData(tf *TorrentFile, a *PieceArray, priorities []FilePriority, uploaded, downloaded int64) (ResumeData, error) {
+	rd := ResumeData{InfoHash: tf.InfoHash, Uploaded: uploaded, Downloaded: downloaded}
+
+	a.listSLock.Lock()
+	for node := a.Saved; node != nil; node = node.Next {
+		rd.Saved = append(rd.Saved, node.Value)
+	}
+	a.listSLock.Unlock()
+
+	rd.Pieces = make([]PieceState, len(a.pieces))
+	for i := range a.pieces {
+		a.locks[i].Lock()
+		rd.Pieces[i] = a.pieces[i].state
+		a.locks[i].Unlock()
+	}
+
+	rd.Files = make([]ResumeFile, len(tf.Files))
+	for i, f := range tf.Files {
+		if priorities[i] == PrioritySkip {
+			rd.Files[i] = ResumeFile{Length: -1}
+			rd.Skipped = append(rd.Skipped, i)
+			continue
+		}
+		info, err := os.Stat(strings.Join(f.Path, "/"))
+		if err != nil {
+			return rd, fmt.Errorf("resume: %w", err)
+		}
+		rd.Files[i] = ResumeFile{Length: info.Size(), MTime: info.ModTime().UnixNano()}
+	}
+
+	return rd, nil
+}
+
+func (rd *ResumeData) Encode() []byte {
+	saved := make([]util.Be, len(rd.Saved))
+	for i, r := range rd.Saved {
+		saved[i] = util.Be{Tag: util.BeList, List: []util.Be{{Tag: util.BeInt, Int: r.First}, {Tag: util.BeInt, Int: r.Second}}}
+	}
+
+	pieces := make([]byte, len(rd.Pieces))
+	for i, state := range rd.Pieces {
+		pieces[i] = byte(state)
+	}
+
+	files := make([]util.Be, len(rd.Files))
+	for i, f := range rd.Files {
+		dict := map[string]util.Be{
+			"length": {Tag: util.BeInt, Int: f.Length},
+			"mtime":  {Tag: util.BeInt, Int: f.MTime},
+		}
+		files[i] = util.Be{Tag: util.BeDict, Dict: &dict}
+	}
+
+	skipped := make([]util.Be, len(rd.Skipped))
+	for i, index := range rd.Skipped {
+		skipped[i] = util.Be{Tag: util.BeInt, Int: int64(index)}
+	}
+
+	dict := map[string]util.Be{
+		"skipped":      {Tag: util.BeList, List: skipped},
+		"info-hash":    {Tag: util.BeStr, Str: rd.InfoHash[:]},
+		"saved":        {Tag: util.BeList, List: saved},
+		"pieces":       {Tag: util.BeStr, Str: pieces},
+		"files":        {Tag: util.BeList, List: files},
+		"uploaded":     {Tag: util.BeInt, Int: rd.Uploaded},
+		"downloaded":   {Tag: util.BeInt, Int: rd.Downloaded},
+		"seeding-time": {Tag: util.BeInt, Int: int64(rd.SeedingTime / time.Second)},
+	}
+	return util.Encode(&util.Be{Tag: util.BeDict, Dict: &dict})
+}
+
+func DecodeResumeData(data []byte) (ResumeData, error) {
+	rd := ResumeData{}
+	be, err := util.Decode(data)
+	if err != nil {
+		return rd, fmt.Errorf("resume: %w", err)
+	}
+	if be.Tag != util.BeDict || be.Dict == nil {
+		return rd, errors.New("resume: not a dict")
+	}
+	dict := *be.Dict
+
+	if len(dict["info-hash"].Str) != 20 {
+		return rd, errors.New("resume: wrong info hash")
+	}
+	copy(rd.InfoHash[:], dict["info-hash"].Str)
+
+	for _, v := range dict["saved"].List {
+		if len(v.List) != 2 {
+			return rd, errors.New("resume: wrong saved range")
+		}
+		rd.Saved = append(rd.Saved, util.Pair[int64]{First: v.List[0].Int, Second: v.List[1].Int})
+	}
+
+	rd.Pieces = make([]PieceState, len(dict["pieces"].Str))
+	for i, state := range dict["pieces"].Str {
+		rd.Pieces[i] = PieceState(state)
+	}
+
+	for _, v := range dict["files"].List {
+		if v.Dict == nil {
+			return rd, errors.New("resume: wrong file entry")
+		}
+		rd.Files = append(rd.Files, ResumeFile{Length: (*v.Dict)["length"].Int, MTime: (*v.Dict)["mtime"].Int})
+	}
+
+	for _, v := range dict["skipped"].List {
+		rd.Skipped = append(rd.Skipped, int(v.Int))
+	}
+
+	rd.Uploaded = dict["uploaded"].Int
+	rd.Downloaded = dict["downloaded"].Int
+	rd.SeedingTime = time.Duration(dict["seeding-time"].Int) * time.Second
+	return rd, nil
+}
+
+// WriteResume replaces resume file atomically.
+func WriteResume(path string, rd *ResumeData) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, rd.Encode(), 0644); err != nil {
+		return fmt.Errorf("resume: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("resume: %w", err)
+	}
+	return nil
+}
+
+func ReadResume(path string) (ResumeData, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ResumeData{}, fmt.Errorf("resume: %w", err)
+	}
+	return DecodeResumeData(data)
+}
+
+// Check reports whether resume data still describes files of tf on disk.
+// Skipped files must be the same: data of a boundary piece is split between a
+// file and the part file, so a file skipped or unskipped since has to be rechecked.
+func (rd *ResumeData) Check(tf *TorrentFile, priorities []FilePriority) error {
+	if !bytes.Equal(rd.InfoHash[:], tf.InfoHash[:]) {
+		return errors.New("resume: info hash mismatch")
+	}
+	if len(rd.Pieces) != len(tf.Pieces) {
+		return errors.New("resume: piece count mismatch")
+	}
+	if len(rd.Files) != len(tf.Files) {
+		return errors.New("resume: file count mismatch")
+	}
+	var skipped []int
+	for i, prio := range priorities {
+		if prio == PrioritySkip {
+			skipped = append(skipped, i)
+		}
+	}
+	if !slices.Equal(skipped, rd.Skipped) {
+		return errors.New("resume: skipped files changed")
+	}
+	if len(skipped) > 0 {
+		if _, err := os.Stat(PartPath(tf.InfoHash)); err != nil {
+			return fmt.Errorf("resume: %w", err)
+		}
+	}
+
+	for i, f := range tf.Files {
+		if priorities[i] == PrioritySkip {
+			continue
+		}
+		path := strings.Join(f.Path, "/")
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("resume: %w", err)
+		}
+		// files that grow as written may be shorter
+		if info.Size() != rd.Files[i].Length || info.Size() > f.Length {
+			return fmt.Errorf("resume: size of %s changed", path)
+		}
+		if info.ModTime().UnixNano() != rd.Files[i].MTime {
+			return fmt.Errorf("resume: %s was modified", path)
+		}
+	}
+	return nil
+}
+
+// Apply marks saved pieces in a and returns count of restored bytes.
+// Pieces that were not saved yet start from scratch.
+func (rd *ResumeData) Apply(a *PieceArray) int64 {
+	var restored int64
+	for i, state := range rd.Pieces {
+		if state != Saved {
+			continue
+		}
+		lw, up := a.pieceBounds(i)
+		if !rangesContain(rd.Saved, lw, up) {
+			continue
+		}
+		a.locks[i].Lock()
+		a.pieces[i].state = Saved
+		a.addBuffered(-int64(len(a.pieces[i].data)))
+		a.pieces[i].data = nil
+		a.pieces[i].downloaded = nil
+		a.locks[i].Unlock()
+		a.listSLock.Lock()
+		a.Saved = util.InsertRange(a.Saved, lw, up)
+		a.notifySaved()
+		a.listSLock.Unlock()
+		restored += up - lw
+	}
+	return restored
+}
+
+func rangesContain(ranges []util.Pair[int64], a, b int64) bool {
+	for _, r := range ranges {
+		if r.First <= a && r.Second >= b {
+			return true
+		}
+	}
+	return false
+}
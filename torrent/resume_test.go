@@ -0,0 +1,108 @@
+package torrent_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/torrent"
+	"github.com/username918r818/torrent-client/util"
+)
+
+func TestResumeData(t *testing.T) {
+	tempDir := t.TempDir()
+
+	tf := torrent.TorrentFile{PieceLength: 4, Pieces: make([][20]byte, 3)}
+	tf.InfoHash[0] = 1
+	tf.Files = []struct {
+		Length int64
+		Path   []string
+	}{
+		{Length: 10, Path: []string{tempDir, "file.txt"}},
+	}
+	path := filepath.Join(tempDir, "file.txt")
+	if err := os.WriteFile(path, make([]byte, 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	normal := []torrent.FilePriority{torrent.PriorityNormal}
+
+	rd := torrent.ResumeData{
+		InfoHash:    tf.InfoHash,
+		Saved:       []util.Pair[int64]{{First: 0, Second: 4}, {First: 8, Second: 10}},
+		Pieces:      []torrent.PieceState{torrent.Saved, torrent.Validated, torrent.Saved},
+		Files:       []torrent.ResumeFile{{Length: 10, MTime: info.ModTime().UnixNano()}},
+		Uploaded:    7,
+		Downloaded:  6,
+		SeedingTime: 90 * time.Second,
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		resumePath := filepath.Join(tempDir, torrent.ResumePath(tf.InfoHash))
+		if err := torrent.WriteResume(resumePath, &rd); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		got, err := torrent.ReadResume(resumePath)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if got.InfoHash != rd.InfoHash || len(got.Saved) != 2 || got.Saved[1] != rd.Saved[1] {
+			t.Fatalf("unexpected resume data: %+v", got)
+		}
+		if len(got.Pieces) != 3 || got.Pieces[1] != torrent.Validated {
+			t.Fatalf("unexpected piece states: %v", got.Pieces)
+		}
+		if got.Files[0] != rd.Files[0] || got.Uploaded != 7 || got.Downloaded != 6 || got.SeedingTime != 90*time.Second {
+			t.Fatalf("unexpected resume data: %+v", got)
+		}
+	})
+
+	t.Run("check and apply", func(t *testing.T) {
+		if err := rd.Check(&tf, normal); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		a := torrent.InitPieceArray(10, 4)
+		restored := rd.Apply(&a)
+		if restored != 6 {
+			t.Fatalf("expected 6 restored bytes, got %d", restored)
+		}
+		if !util.Contains(a.Saved, 8, 10) || util.Contains(a.Saved, 4, 8) {
+			t.Fatalf("unexpected saved ranges")
+		}
+	})
+
+	t.Run("skipped files changed", func(t *testing.T) {
+		if err := rd.Check(&tf, []torrent.FilePriority{torrent.PrioritySkip}); err == nil {
+			t.Fatal("expected error, but got none")
+		}
+		skipped := rd
+		skipped.Skipped = []int{0}
+		if err := skipped.Check(&tf, normal); err == nil {
+			t.Fatal("expected error, but got none")
+		}
+	})
+
+	t.Run("missing part file", func(t *testing.T) {
+		skipped := rd
+		skipped.Skipped = []int{0}
+		if err := skipped.Check(&tf, []torrent.FilePriority{torrent.PrioritySkip}); err == nil {
+			t.Fatal("expected error, but got none")
+		}
+	})
+
+	t.Run("modified file", func(t *testing.T) {
+		later := info.ModTime().Add(time.Second)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+		if err := rd.Check(&tf, normal); err == nil {
+			t.Fatal("expected error, but got none")
+		}
+	})
+}
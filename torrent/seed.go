@@ -0,0 +1,113 @@
+package torrent
+
+import (
+	"fmt"
+	"time"
+)
+
+var SeedCheckInterval = 5 * time.Second // how often seed limits are checked
+
+// SeedAction is what is done with a torrent once it reached its seed limits.
+type SeedAction int
+
+const (
+	SeedPause SeedAction = iota
+	SeedRemove
+	SeedRemoveData // remove the torrent and delete its files
+)
+
+func (a SeedAction) String() string {
+	switch a {
+	case SeedPause:
+		return "pause"
+	case SeedRemove:
+		return "remove"
+	case SeedRemoveData:
+		return "delete"
+	}
+	return "unknown"
+}
+
+func ParseSeedAction(s string) (SeedAction, error) {
+	for _, a := range []SeedAction{SeedPause, SeedRemove, SeedRemoveData} {
+		if s == a.String() {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown seed action %q", s)
+}
+
+// SeedLimits stop seeding once a torrent uploaded Ratio times as much as it
+// downloaded or seeded for Time, whichever comes first. Zero means no limit.
+// Uploads are not served yet, so Ratio is not reached while Uploaded stays 0.
+type SeedLimits struct {
+	Ratio  float64
+	Time   time.Duration
+	Action SeedAction
+}
+
+func (l SeedLimits) reached(st TransferStats) bool {
+	return l.Ratio > 0 && st.Ratio >= l.Ratio || l.Time > 0 && st.SeedingTime >= l.Time
+}
+
+// TransferStats are totals of a torrent over all its runs, they are kept in
+// resume data.
+type TransferStats struct {
+	Uploaded    int64
+	Downloaded  int64
+	Ratio       float64 // uploaded to downloaded, or to wanted bytes if nothing was downloaded
+	SeedingTime time.Duration
+}
+
+func newTransferStats(uploaded, downloaded, wanted int64, seeding time.Duration) TransferStats {
+	st := TransferStats{Uploaded: uploaded, Downloaded: downloaded, SeedingTime: seeding}
+	// data found on disk was not downloaded, it is shared as if it was
+	if base := downloaded; base > 0 || wanted > 0 {
+		if base == 0 {
+			base = wanted
+		}
+		st.Ratio = float64(uploaded) / float64(base)
+	}
+	return st
+}
+
+// SetSeedLimits sets limits of the torrent, nil makes it use limits of its
+// session. Limits are checked while the torrent seeds.
+func (h *Handle) SetSeedLimits(l *SeedLimits) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seedLimits = l
+}
+
+// Transfer returns totals of the torrent as of the last check of seed limits.
+func (h *Handle) Transfer() TransferStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.transfer
+}
+
+func (h *Handle) setTransfer(st TransferStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.transfer = st
+}
+
+// SetSeedLimits sets limits of torrents that have none of their own.
+func (s *Session) SetSeedLimits(l SeedLimits) {
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	s.seedLimits = l
+}
+
+// seedLimitsOf returns limits of h or of the session if h has none.
+func (s *Session) seedLimitsOf(h *Handle) SeedLimits {
+	h.mu.Lock()
+	l := h.seedLimits
+	h.mu.Unlock()
+	if l != nil {
+		return *l
+	}
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	return s.seedLimits
+}
@@ -0,0 +1,32 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeedRatio(t *testing.T) {
+	cases := []struct {
+		name                         string
+		uploaded, downloaded, wanted int64
+		ratio                        float64
+		reached                      bool
+	}{
+		{"downloaded", 300, 100, 1000, 3, true},
+		{"found on disk", 500, 0, 1000, 0.5, false},
+		{"nothing wanted", 500, 0, 0, 0, false},
+	}
+	limits := SeedLimits{Ratio: 2, Time: time.Hour}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			st := newTransferStats(c.uploaded, c.downloaded, c.wanted, time.Minute)
+			if st.Ratio != c.ratio || limits.reached(st) != c.reached {
+				t.Fatalf("expected ratio %v reached %v, got %v %v", c.ratio, c.reached, st.Ratio, limits.reached(st))
+			}
+		})
+	}
+
+	if !limits.reached(newTransferStats(0, 100, 100, time.Hour)) {
+		t.Fatal("expected seeding time limit to be reached")
+	}
+}
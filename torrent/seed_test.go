@@ -0,0 +1,81 @@
+package torrent_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/torrent"
+	"github.com/username918r818/torrent-client/torrent/torrenttest"
+)
+
+func TestSeedLimits(t *testing.T) {
+	t.Chdir(t.TempDir())
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer tracker.Close()
+
+	interval := torrent.SeedCheckInterval
+	torrent.SeedCheckInterval = 10 * time.Millisecond
+	defer func() { torrent.SeedCheckInterval = interval }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := torrent.NewSession(ctx, 6881, torrent.DefaultConfig())
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+	s.SetSeedLimits(torrent.SeedLimits{Time: 50 * time.Millisecond, Action: torrent.SeedRemoveData})
+
+	// both torrents are complete on disk and seed right away
+	add := func(dir string, hash byte) *torrent.Handle {
+		tf := torrenttest.NewTorrent(dir, "a.txt", "b.txt")
+		tf.Announce = tracker.URL
+		tf.InfoHash[0] = hash
+		if err := torrenttest.WriteFiles(&tf, torrenttest.Data[:6], torrenttest.Data[6:]); err != nil {
+			t.Fatal(err)
+		}
+		return torrent.NewHandle(tf)
+	}
+
+	t.Run("torrent limits", func(t *testing.T) {
+		h := add("paused", 1)
+		h.SetSeedLimits(&torrent.SeedLimits{Time: 50 * time.Millisecond, Action: torrent.SeedPause})
+		if err := s.Add(h); err != nil {
+			t.Fatal(err)
+		}
+		waitState(t, h, torrent.StateSeeding)
+		waitState(t, h, torrent.StatePaused)
+		if st := h.Transfer(); st.SeedingTime < 50*time.Millisecond {
+			t.Fatalf("expected seeding time of at least 50ms, got %v", st.SeedingTime)
+		}
+
+		// resumed by the user, it keeps seeding
+		if err := h.Resume(ctx); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * torrent.SeedCheckInterval)
+		if h.State() != torrent.StateSeeding {
+			t.Fatalf("expected seeding torrent, got %v", h.State())
+		}
+	})
+
+	t.Run("session limits", func(t *testing.T) {
+		h := add("removed", 2)
+		if err := s.Add(h); err != nil {
+			t.Fatal(err)
+		}
+		waitState(t, h, torrent.StateSeeding)
+		waitState(t, h, torrent.StateStopped)
+		if s.Get(h.File.InfoHash) != nil {
+			t.Fatal("expected the torrent to be removed")
+		}
+		if _, err := os.Stat("removed"); !os.IsNotExist(err) {
+			t.Fatalf("expected files to be deleted, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package torrent
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/username918r818/torrent-client/file"
+	"github.com/username918r818/torrent-client/limit"
+	"github.com/username918r818/torrent-client/message"
+)
+
+// peerLimit counts peer connections of all torrents of a session.
+type peerLimit struct {
+	mu   sync.Mutex
+	max  int // no limit if zero
+	used int
+}
+
+func (l *peerLimit) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.used >= l.max {
+		return false
+	}
+	l.used++
+	return true
+}
+
+func (l *peerLimit) release(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.used -= n
+}
+
+type sessionTorrent struct {
+	h      *Handle
+	ctx    context.Context // of the supervisor
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the supervisor returns
+	err    error         // returned by the supervisor
+
+	// used by the queue, guarded by Session.mu
+	seeding  bool      // the torrent was seeding when it was active last time
+	received int64     // Received of its pieces when it was checked last time
+	progress time.Time // when it received data or was started last time
+	stalled  bool
+}
+
+// Session runs several torrents at once. Its torrents share the port announced
+// to trackers, the peer id, file workers, the limit of peer connections and
+// bandwidth limits.
+type Session struct {
+	ctx      context.Context
+	cfg      Config
+	port     int
+	peerId   [20]byte
+	toSave   chan<- message.SaveRange
+	storages *file.Router
+	peers    peerLimit
+	down, up *limit.Bucket
+
+	limitsMu   sync.Mutex // guards limits and seedLimits
+	limits     speedLimits
+	seedLimits SeedLimits
+
+	mu        sync.Mutex        // guards torrents and queue
+	torrents  []*sessionTorrent // in order of the queue
+	queue     queueLimits
+	queueWake chan struct{}
+	running   sync.WaitGroup // of supervisors
+	stopped   chan struct{}  // closed when all torrents and file workers are stopped
+}
+
+// NewSession starts file workers of a session, cfg has to pass Validate. All
+// torrents of the session are stopped when ctx is done, file workers are
+// stopped after them, once torrents have written their data.
+func NewSession(ctx context.Context, port int, cfg Config) *Session {
+	toSave := make(chan message.SaveRange)
+	s := &Session{ctx: ctx, cfg: cfg, port: port, toSave: toSave, storages: file.NewRouter(), stopped: make(chan struct{})}
+	s.down, s.up = limit.NewBucket(0), limit.NewBucket(0)
+	s.queueWake = make(chan struct{}, 1)
+	go s.runSchedule(ctx)
+	go s.runQueue(ctx)
+	copy(s.peerId[:], "-UT0001-"+randomDigits(12))
+
+	workersCtx, stopWorkers := context.WithCancel(context.WithoutCancel(ctx))
+	var workers sync.WaitGroup
+	fileCh := message.FileChannels{ToSaveChannel: toSave}
+	for range cfg.FileWorkers {
+		workers.Go(func() { file.StartFileWorker(workersCtx, fileCh, s.storages) })
+	}
+
+	go func() {
+		<-ctx.Done()
+		// torrents are not added once ctx is done, the lock waits for one being added
+		s.mu.Lock()
+		s.mu.Unlock()
+		s.running.Wait()
+		stopWorkers()
+		workers.Wait()
+		close(s.stopped)
+	}()
+	return s
+}
+
+// Wait waits until ctx of the session is done and all torrents and workers of
+// the session are stopped. It returns errors of torrents that failed to stop
+// cleanly, removed torrents are not counted.
+func (s *Session) Wait() error {
+	<-s.stopped
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, t := range s.torrents {
+		errs = append(errs, t.err)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Session) Port() int {
+	return s.port
+}
+
+func (s *Session) PeerId() [20]byte {
+	return s.peerId
+}
+
+// SetMaxPeers limits peer connections of all torrents together, zero means
+// no limit. Connections above a lowered limit are not closed.
+func (s *Session) SetMaxPeers(n int) {
+	s.peers.mu.Lock()
+	defer s.peers.mu.Unlock()
+	s.peers.max = n
+}
+
+func (s *Session) find(infoHash [20]byte) int {
+	return slices.IndexFunc(s.torrents, func(t *sessionTorrent) bool { return t.h.File.InfoHash == infoHash })
+}
+
+// Add starts a supervisor of the torrent of h.
+func (s *Session) Add(h *Handle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.find(h.File.InfoHash) >= 0 {
+		return errors.New("session: torrent is already added")
+	}
+	if s.ctx.Err() != nil {
+		return errors.New("session: session is stopped")
+	}
+	opts, err := h.start()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	t := &sessionTorrent{h: h, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	s.torrents = append(s.torrents, t)
+	s.wakeQueue()
+	s.running.Go(func() {
+		defer close(t.done)
+		t.err = s.run(ctx, h, opts)
+	})
+	return nil
+}
+
+// Remove stops the torrent and waits until its supervisor returns, the error
+// of the supervisor is returned. Files and resume data of the torrent are
+// deleted if deleteData is set.
+func (s *Session) Remove(infoHash [20]byte, deleteData bool) error {
+	s.mu.Lock()
+	i := s.find(infoHash)
+	if i < 0 {
+		s.mu.Unlock()
+		return errors.New("session: torrent is not added")
+	}
+	t := s.torrents[i]
+	s.torrents = slices.Delete(s.torrents, i, i+1)
+	s.mu.Unlock()
+
+	t.h.removeData.Store(deleteData)
+	t.cancel()
+	<-t.done
+	return t.err
+}
+
+// Get returns the handle of an added torrent or nil.
+func (s *Session) Get(infoHash [20]byte) *Handle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.find(infoHash); i >= 0 {
+		return s.torrents[i].h
+	}
+	return nil
+}
+
+// List returns handles of added torrents in order of the queue, it is the
+// order they were added unless they were moved.
+func (s *Session) List() []*Handle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handles := make([]*Handle, len(s.torrents))
+	for i, t := range s.torrents {
+		handles[i] = t.h
+	}
+	return handles
+}
@@ -0,0 +1,83 @@
+package torrent
+
+import (
+	"context"
+	"crypto/sha1"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	t.Chdir(t.TempDir())
+	events := make(chan string, 16)
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events <- r.URL.Query().Get("event")
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer tracker.Close()
+
+	data := []byte("0123456789")
+	tf := TorrentFile{Announce: tracker.URL, PieceLength: 4}
+	for i := 0; i < len(data); i += 4 {
+		tf.Pieces = append(tf.Pieces, sha1.Sum(data[i:min(i+4, len(data))]))
+	}
+	tf.Files = []struct {
+		Length int64
+		Path   []string
+	}{{Length: 10, Path: []string{"a.txt"}}}
+	h := NewHandle(tf)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewSession(ctx, 6881, DefaultConfig())
+	if err := s.Add(h); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.NewReader(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-events; got != "started" {
+		t.Fatalf("expected started announce, got %q", got)
+	}
+
+	// a validated piece is written on stop, long before Config.FlushAge
+	a := h.Pieces()
+	a.locks[0].Lock()
+	a.pieces[0].state = Validated
+	a.locks[0].Unlock()
+	a.validLock.Lock()
+	a.validPieces[0] = data[:4]
+	a.validLock.Unlock()
+	a.addToSave(0, 4)
+	cancel()
+
+	done := make(chan error)
+	go func() { done <- s.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	case <-time.After(DefaultConfig().FlushAge):
+		t.Fatal("expected the session to stop")
+	}
+
+	if !a.IsSaved(0, 4) {
+		t.Fatal("expected validated piece to be saved")
+	}
+	// the file is not complete, it keeps its suffix
+	if got, err := os.ReadFile("a.txt" + IncompleteSuffix); err != nil || string(got[:4]) != "0123" {
+		t.Fatalf("expected 0123 on disk, got %q, %v", got, err)
+	}
+	if _, err := os.Stat(ResumePath(tf.InfoHash)); err != nil {
+		t.Fatalf("expected resume data, got %v", err)
+	}
+	if got := <-events; got != "stopped" {
+		t.Fatalf("expected stopped announce, got %q", got)
+	}
+	if h.State() != StateStopped {
+		t.Fatalf("expected stopped torrent, got %v", h.State())
+	}
+}
@@ -0,0 +1,158 @@
+package torrent_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/torrent"
+	"github.com/username918r818/torrent-client/torrent/torrenttest"
+)
+
+func TestSession(t *testing.T) {
+	t.Chdir(t.TempDir())
+	events := make(chan string, 16)
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events <- r.URL.Query().Get("info_hash")[:1] + r.URL.Query().Get("event")
+		w.Write([]byte("d8:intervali1800e5:peers0:e"))
+	}))
+	defer tracker.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := torrent.NewSession(ctx, 6881, torrent.DefaultConfig())
+
+	// both torrents are complete on disk, recheck on start marks them saved
+	var handles []*torrent.Handle
+	for i, dir := range []string{"first", "second"} {
+		tf := torrenttest.NewTorrent(dir, "a.txt", "b.txt")
+		tf.Announce = tracker.URL
+		tf.InfoHash[0] = byte(i + 1)
+		if err := torrenttest.WriteFiles(&tf, torrenttest.Data[:6], torrenttest.Data[6:]); err != nil {
+			t.Fatal(err)
+		}
+		h := torrent.NewHandle(tf)
+		if err := s.Add(h); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		handles = append(handles, h)
+	}
+
+	t.Run("add twice", func(t *testing.T) {
+		if err := s.Add(torrent.NewHandle(handles[0].File)); err == nil {
+			t.Fatal("expected error for torrent added twice, but got none")
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		list := s.List()
+		if len(list) != 2 || list[0] != handles[0] || list[1] != handles[1] {
+			t.Fatalf("expected torrents in order they were added, got %v", list)
+		}
+		if s.Get(handles[1].File.InfoHash) != handles[1] {
+			t.Fatal("expected to get the second torrent")
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		for _, h := range handles {
+			r, err := h.NewReader(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(r)
+			if err != nil || string(data) != torrenttest.Data {
+				t.Fatalf("expected %q, got %q, %v", torrenttest.Data, data, err)
+			}
+		}
+	})
+
+	t.Run("pause", func(t *testing.T) {
+		h := handles[0]
+		waitState(t, h, torrent.StateSeeding)
+		expectEvent(t, events, "\x01started")
+		if err := h.Pause(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		waitState(t, h, torrent.StatePaused)
+		expectEvent(t, events, "\x01stopped")
+
+		if err := h.Resume(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		waitState(t, h, torrent.StateSeeding)
+		expectEvent(t, events, "\x01started")
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		if err := s.Remove(handles[0].File.InfoHash, false); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if list := s.List(); len(list) != 1 || list[0] != handles[1] {
+			t.Fatalf("expected only the second torrent, got %v", list)
+		}
+		if handles[0].State() != torrent.StateStopped {
+			t.Fatalf("expected stopped torrent, got %v", handles[0].State())
+		}
+		if _, err := os.Stat(filepath.Join("first", "a.txt")); err != nil {
+			t.Fatalf("expected files to be kept, got %v", err)
+		}
+		if err := s.Remove(handles[0].File.InfoHash, false); err == nil {
+			t.Fatal("expected error for removed torrent, but got none")
+		}
+		// commands don't wait for a supervisor that is gone
+		if err := handles[0].Pause(context.Background()); !errors.Is(err, torrent.ErrStopped) {
+			t.Fatalf("expected ErrStopped, got %v", err)
+		}
+		// a handle runs once, the torrent is added again with a new one
+		if err := s.Add(handles[0]); err == nil {
+			t.Fatal("expected error for started handle, but got none")
+		}
+	})
+
+	t.Run("remove with data", func(t *testing.T) {
+		if err := s.Remove(handles[1].File.InfoHash, true); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := os.Stat("second"); !os.IsNotExist(err) {
+			t.Fatalf("expected files to be deleted, got %v", err)
+		}
+	})
+}
+
+func waitState(t *testing.T, h *torrent.Handle, want torrent.TorrentState) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for h.State() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v torrent, got %v", want, h.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// expectEvent skips announces of other torrents until one of the torrent.
+func expectEvent(t *testing.T, events <-chan string, want string) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case got := <-events:
+			if got[0] != want[0] {
+				continue
+			}
+			if got != want {
+				t.Fatalf("expected announce %q, got %q", want, got)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("expected announce %q, got none", want)
+		}
+	}
+}
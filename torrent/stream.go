@@ -0,0 +1,113 @@
+package torrent
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	StreamWindow  int64 = 16 << 20         // bytes ahead of read position downloaded first in sequential mode
+	DeadlineGrace       = 30 * time.Second // a deadline missed by this long is forgotten
+)
+
+// DeadlineId is returned by SetDeadline to remove the deadline later, zero is
+// never used.
+type DeadlineId int
+
+type deadline struct {
+	id          DeadlineId
+	first, last int // piece indexes
+	at          time.Time
+}
+
+// pieceSpan is a range of piece indexes [first, last) searched for a task.
+type pieceSpan struct {
+	first, last int
+	prio        FilePriority // PrioritySkip means any priority except skip
+}
+
+// SetSequential makes the picker download pieces in order, starting from the
+// read position, so media can be played before the download finishes.
+func (a *PieceArray) SetSequential(on bool) {
+	a.prioLock.Lock()
+	defer a.prioLock.Unlock()
+	a.sequential = on
+}
+
+// SetReadPosition moves the window of sequential mode.
+func (a *PieceArray) SetReadPosition(offset int64) {
+	a.prioLock.Lock()
+	defer a.prioLock.Unlock()
+	a.readPiece = min(max(int(offset/a.pieceLength), 0), len(a.pieces)-1)
+}
+
+// SetDeadline asks for bytes [from, to) to be downloaded by time at. Pieces with
+// deadlines go before any other, earlier deadlines first. A deadline is
+// forgotten once all its wanted pieces are downloaded, once it is removed or
+// DeadlineGrace after time at.
+func (a *PieceArray) SetDeadline(from, to int64, at time.Time) DeadlineId {
+	if from >= to {
+		return 0
+	}
+	d := deadline{first: int(from / a.pieceLength), last: int((to - 1) / a.pieceLength), at: at}
+	d.first = max(d.first, 0)
+	d.last = min(d.last, len(a.pieces)-1)
+
+	a.prioLock.Lock()
+	defer a.prioLock.Unlock()
+	a.lastDeadline++
+	d.id = a.lastDeadline
+	i, _ := slices.BinarySearchFunc(a.deadlines, d, func(e, t deadline) int { return e.at.Compare(t.at) })
+	a.deadlines = slices.Insert(a.deadlines, i, d)
+	return d.id
+}
+
+func (a *PieceArray) RemoveDeadline(id DeadlineId) {
+	a.prioLock.Lock()
+	defer a.prioLock.Unlock()
+	a.deadlines = slices.DeleteFunc(a.deadlines, func(d deadline) bool { return d.id == id })
+}
+
+func (a *PieceArray) ClearDeadlines() {
+	a.prioLock.Lock()
+	defer a.prioLock.Unlock()
+	a.deadlines = nil
+}
+
+// pickOrder returns spans in order they are searched for a task, prioLock has
+// to be held.
+func (a *PieceArray) pickOrder() []pieceSpan {
+	now := time.Now()
+	a.deadlines = slices.DeleteFunc(a.deadlines, func(d deadline) bool {
+		if now.Sub(d.at) > DeadlineGrace {
+			return true
+		}
+		for i := d.first; i <= d.last; i++ {
+			if a.pieces[i].state < Validated && a.priority[i] != PrioritySkip {
+				return false
+			}
+		}
+		return true
+	})
+
+	var spans []pieceSpan
+	for _, d := range a.deadlines {
+		spans = append(spans, pieceSpan{d.first, d.last + 1, PrioritySkip})
+	}
+
+	n := len(a.pieces)
+	if a.sequential {
+		window := int((StreamWindow + a.pieceLength - 1) / a.pieceLength)
+		spans = append(spans, pieceSpan{a.readPiece, min(a.readPiece+window, n), PrioritySkip})
+	}
+
+	// pieces of files with higher priority go first, skipped files are not downloaded
+	for prio := PriorityHigh; prio > PrioritySkip; prio-- {
+		if a.sequential {
+			spans = append(spans, pieceSpan{a.readPiece, n, prio}, pieceSpan{0, a.readPiece, prio})
+		} else {
+			spans = append(spans, pieceSpan{0, n, prio})
+		}
+	}
+	return spans
+}
@@ -0,0 +1,94 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/username918r818/torrent-client/message"
+)
+
+// newStreamArray has eight pieces, the sequential window covers two of them. The
+// last two pieces belong to the second file.
+func newStreamArray(priorities ...FilePriority) (*PieceArray, *TorrentFile) {
+	pieceLength := StreamWindow / 2
+	tf := TorrentFile{PieceLength: pieceLength, Pieces: make([][20]byte, 8)}
+	tf.Files = []struct {
+		Length int64
+		Path   []string
+	}{
+		{Length: 6 * pieceLength, Path: []string{"a.txt"}},
+		{Length: 2 * pieceLength, Path: []string{"b.txt"}},
+	}
+	a := InitPieceArray(8*pieceLength, pieceLength)
+	if priorities != nil {
+		a.setFilePriorities(&tf, priorities)
+	}
+	return &a, &tf
+}
+
+// pickAll returns first pieces of tasks in order they are given to a single peer.
+func pickAll(a *PieceArray) []int64 {
+	tasksPeers := make(map[int][6]byte)
+	var firsts []int64
+	for {
+		task, err := findTask(a, []byte{0xff}, 1, tasksPeers, make(map[[6]byte]message.DownloadRange), [6]byte{1})
+		if err != nil {
+			return firsts
+		}
+		firsts = append(firsts, task.Offset/a.pieceLength)
+	}
+}
+
+func equalPicks(t *testing.T, got []int64, want ...int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected tasks from %v, got %v", want, got)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("expected tasks from %v, got %v", want, got)
+		}
+	}
+}
+
+func TestPickOrder(t *testing.T) {
+	t.Run("sequential", func(t *testing.T) {
+		a, _ := newStreamArray()
+		a.SetSequential(true)
+		a.SetReadPosition(3 * a.pieceLength)
+		// window first, then up to the end, then from the start
+		equalPicks(t, pickAll(a), 3, 5, 7, 0, 2)
+	})
+
+	t.Run("window before priority", func(t *testing.T) {
+		a, _ := newStreamArray(PriorityNormal, PriorityHigh)
+		a.SetSequential(true)
+		spans := a.pickOrder()
+		if spans[0] != (pieceSpan{0, 2, PrioritySkip}) {
+			t.Fatalf("expected window of two pieces first, got %v", spans[0])
+		}
+		equalPicks(t, pickAll(a), 0, 6, 2, 4)
+	})
+
+	t.Run("deadlines", func(t *testing.T) {
+		a, _ := newStreamArray()
+		now := time.Now()
+		a.SetDeadline(5*a.pieceLength, 6*a.pieceLength, now.Add(time.Second))
+		a.SetDeadline(2*a.pieceLength+1, 2*a.pieceLength+2, now)
+		id := a.SetDeadline(7*a.pieceLength, 8*a.pieceLength, now.Add(-time.Second))
+		a.RemoveDeadline(id)
+		// earlier deadline first, removed one is not used
+		equalPicks(t, pickAll(a), 2, 5, 0, 3, 6)
+	})
+
+	t.Run("forgotten deadlines", func(t *testing.T) {
+		a, _ := newStreamArray(PriorityNormal, PrioritySkip)
+		a.SetDeadline(0, a.pieceLength, time.Now().Add(-2*DeadlineGrace))
+		// the rest of this deadline is skipped
+		a.SetDeadline(5*a.pieceLength, 8*a.pieceLength, time.Now())
+		a.pieces[5].state = Validated
+		if spans := a.pickOrder(); len(a.deadlines) != 0 || spans[0].prio == PrioritySkip {
+			t.Fatalf("expected deadlines to be forgotten, got %v", a.deadlines)
+		}
+	})
+}
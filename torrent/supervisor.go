@@ -2,13 +2,18 @@ package torrent
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/username918r818/torrent-client/file"
+	"github.com/username918r818/torrent-client/limit"
 	"github.com/username918r818/torrent-client/message"
 	"github.com/username918r818/torrent-client/util"
 )
@@ -22,6 +27,7 @@ const (
 	PeerChoking
 	PeerDownloading
 	PeerWaiting
+	PeerSnubbed
 )
 
 func createBitField(pieces int) []byte {
@@ -48,59 +54,70 @@ func getPiece(piece int, bitfield []byte) bool {
 }
 
 func findTask(pieceArray *PieceArray, bitfield []byte, length int, tasksPeers map[int][6]byte, peerTasks map[[6]byte]message.DownloadRange, peer [6]byte) (message.DownloadRange, error) {
-	var msg message.DownloadRange
-	msg.PieceLength = pieceArray.pieceLength
-	for i, v := range pieceArray.pieces {
-		if _, ok := tasksPeers[i]; !ok && v.state == NotStarted && getPiece(i, bitfield) {
-			curLength := msg.PieceLength
-			if i == len(pieceArray.pieces)-1 {
-				curLength = pieceArray.lastPieceLength
-				slog.Info("supervisor: gaved last piece task")
-			}
-			switch msg.Length {
-			case 0:
-				msg.Offset = int64(i) * msg.PieceLength
-				msg.Length = curLength
-				tasksPeers[i] = peer
-			default:
-				msg.Length += curLength
-				if msg.Length >= int64(length) {
+	if pieceArray.overBudget() {
+		return message.DownloadRange{PieceLength: pieceArray.pieceLength}, errors.New("supervisor: memory budget is exceeded")
+	}
+
+	pieceArray.prioLock.Lock()
+	defer pieceArray.prioLock.Unlock()
+
+	for _, span := range pieceArray.pickOrder() {
+		var msg message.DownloadRange
+		msg.PieceLength = pieceArray.pieceLength
+		for i := span.first; i < span.last; i++ {
+			v := pieceArray.pieces[i]
+			prio := pieceArray.priority[i]
+			if _, ok := tasksPeers[i]; !ok && v.state == NotStarted && prio != PrioritySkip && (span.prio == PrioritySkip || prio == span.prio) && getPiece(i, bitfield) {
+				curLength := msg.PieceLength
+				if i == len(pieceArray.pieces)-1 {
+					curLength = pieceArray.lastPieceLength
+					slog.Info("supervisor: gaved last piece task")
+				}
+				switch msg.Length {
+				case 0:
+					msg.Offset = int64(i) * msg.PieceLength
+					msg.Length = curLength
 					tasksPeers[i] = peer
+				default:
+					msg.Length += curLength
+					if msg.Length >= int64(length) {
+						tasksPeers[i] = peer
+						peerTasks[peer] = msg
+						return msg, nil
+					}
+
+				}
+			} else {
+				if msg.Length > 0 {
 					peerTasks[peer] = msg
 					return msg, nil
 				}
-
-			}
-		} else {
-			if msg.Length > 0 {
-				peerTasks[peer] = msg
-				return msg, nil
 			}
 		}
-	}
-	if msg.Length > 0 {
-		peerTasks[peer] = msg
-		return msg, nil
+		if msg.Length > 0 {
+			peerTasks[peer] = msg
+			return msg, nil
+		}
 	}
 
-	return msg, errors.New("supervisor: task not found")
+	return message.DownloadRange{PieceLength: pieceArray.pieceLength}, errors.New("supervisor: task not found")
 }
 
-func newPeer(ctx context.Context, peerCh message.PeerChannels, peer [6]byte, pieceArray *PieceArray, infoHash, peerId [20]byte, wgPeers *sync.WaitGroup, ch *message.SupervisorChannels, peerState *map[[6]byte]peerState) {
+func newPeer(ctx context.Context, peerCh message.PeerChannels, peer [6]byte, pieceArray *PieceArray, infoHash, peerId [20]byte, bw bandwidth, cfg *Config, wgPeers *sync.WaitGroup, ch *message.SupervisorChannels, peerState *map[[6]byte]peerState) {
 	newCh := make(chan message.DownloadRange, 1)
 	newPeerCh := peerCh
 	newPeerCh.ToDownload = newCh
 	ch.ToPeerWorkerToDownload[peer] = newCh
 	wgPeers.Go(func() {
-		StartPeerWorker(ctx, newPeerCh, pieceArray, peer, infoHash, peerId)
+		StartPeerWorker(ctx, newPeerCh, pieceArray, peer, infoHash, peerId, bw, cfg)
 	})
 	(*peerState)[peer] = PeerChoking
 }
 
-func queuePeer(peer [6]byte, state map[[6]byte]peerState, ch chan<- message.Peers) {
+func queuePeer(peer [6]byte, state map[[6]byte]peerState, ch chan<- message.Peers, delay time.Duration) {
 	state[peer] = PeerNotFound
 	go func() {
-		time.Sleep(20 * time.Second)
+		time.Sleep(delay)
 		ch <- [][6]byte{peer}
 	}()
 }
@@ -166,47 +183,200 @@ func redistributeTasksToWaiting(
 	return redistributed
 }
 
-func StartSupervisor(ctx context.Context, torrentFile TorrentFile, port int) {
-	ch, traCh, peerCh, pieceCh, fileCh := message.GetChannels()
-	ch.ToPeerWorkerToDownload = make(map[[6]byte]chan<- message.DownloadRange)
+func logRecheck(p RecheckProgress) {
+	slog.Info(fmt.Sprintf("Supervisor: rechecked %d/%d pieces, %d valid", p.Checked, p.Total, p.Valid))
+}
 
-	trackerSession := &TrackerSession{}
-	peerId := "-UT0001-" + randomDigits(12)
-	copy(trackerSession.PeerId[:], peerId)
+var AnnounceTimeout = 5 * time.Second // of the stopped announce
 
-	trackerSession.TorrentFile = &torrentFile
-	trackerSession.Port = port
-	trackerSession.Left = torrentFile.Files[0].Length
+// StartSupervisor runs a single torrent in a session of its own with default
+// config, it returns once the torrent is stopped after ctx is done.
+func StartSupervisor(ctx context.Context, h *Handle, port int) {
+	s := NewSession(ctx, port, DefaultConfig())
+	if err := s.Add(h); err != nil {
+		slog.Error("Supervisor: " + err.Error())
+		return
+	}
+	if err := s.Wait(); err != nil {
+		slog.Error("Supervisor: " + err.Error())
+	}
+}
 
-	var wgTracker, wgFiles, wgPiece, wgPeers sync.WaitGroup
-	wgTracker.Go(func() { StartWorkerTracker(ctx, trackerSession, traCh) })
+// run is the supervisor of a torrent of the session. Once ctx is done it stops
+// the torrent: validated data is written and synced, resume data is saved and
+// the tracker is told. It returns an error if any of it failed.
+func (s *Session) run(ctx context.Context, h *Handle, opts startOptions) error {
+	torrentFile := h.File
+	priorities, storage := opts.priorities, opts.storage
+	ch, traCh, peerCh, pieceCh, _ := message.GetChannels()
+	ch.ToPeerWorkerToDownload = make(map[[6]byte]chan<- message.DownloadRange)
+	ch.Control = h.control
+	pieceCh.FileWorkerToSave = s.toSave
 
-	for range 2 {
-		wgFiles.Go(func() { file.StartFileWorker(ctx, fileCh) })
-	}
+	defer func() {
+		if h.State() != StateFailed {
+			h.setState(StateStopped)
+		}
+		close(h.stopped)
+	}()
 
-	var totalBytes int64
+	trackerSession := newTrackerSession()
+	trackerSession.PeerId = s.peerId
+	trackerSession.TorrentFile = &torrentFile
+	trackerSession.Port = s.port
+	bw := bandwidth{
+		down:     limit.Limiter{s.down, h.down},
+		up:       limit.Limiter{s.up, h.up},
+		peerDown: h.peerDown,
+		peerUp:   h.peerUp,
+	}
 
-	fileMap, err := file.Alloc(torrentFile.Files)
-	if err != nil {
-		slog.ErrorContext(ctx, "Supervisor: "+err.Error())
-		return
+	var wgTracker, wgPiece, wgPeers sync.WaitGroup
+	// piece workers and the tracker outlive ctx until data is written on stop
+	workCtx, stopWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopWork()
+	trackerCtx, stopTracker := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopTracker()
+
+	// files on disk may have a suffix or be moved, their paths are in onDisk
+	files := newLayout(&torrentFile, opts.suffix, opts.moveTo)
+	onDisk := &files.files
+
+	// resume data has to be checked before files are touched
+	resumePath := ResumePath(torrentFile.InfoHash)
+	resumeData, resumeErr := ReadResume(resumePath)
+	if resumeErr == nil {
+		resumeErr = resumeData.Check(onDisk, priorities)
+	}
+	if resumeErr != nil {
+		slog.Info("Supervisor: starting from scratch: " + resumeErr.Error())
+	}
+	if resumeData.InfoHash != torrentFile.InfoHash {
+		resumeData = ResumeData{}
 	}
 
-	for _, v := range torrentFile.Files {
-		totalBytes += v.Length
+	// data left by another client or by a run without resume data is hashed again
+	needRecheck := false
+	if resumeErr != nil {
+		for _, f := range onDisk.Files {
+			if info, err := os.Stat(strings.Join(f.Path, "/")); err == nil && info.Size() > 0 {
+				needRecheck = true
+			}
+		}
+	}
 
+	// files of storages on disk are created here, other storages keep them on their own
+	if storage == nil {
+		storage = file.NewDisk()
+	}
+	if disk, ok := storage.(diskStorage); ok {
+		if err := allocFiles(onDisk, priorities, opts.allocMode, disk); err != nil {
+			slog.ErrorContext(ctx, "Supervisor: "+err.Error())
+			h.fail(err)
+			return err
+		}
 	}
 
+	// file workers of the session find files of the torrent by its info hash
+	s.storages.Set(torrentFile.InfoHash, storage)
+	defer s.storages.Remove(torrentFile.InfoHash)
+
 	pieceFile := make(chan message.IsRangeSaved)
 	pieceCh.FileWorkerIsSaved = pieceFile
 	pieceCh.CallBack = pieceFile
 
-	pieceArray := InitPieceArray(totalBytes, torrentFile.PieceLength)
+	pieceArray := h.pieces
+	h.setFiles(storage)
+
+	if resumeErr == nil {
+		trackerSession.Restored = resumeData.Apply(pieceArray)
+		slog.Info(fmt.Sprintf("Supervisor: restored %d bytes from resume data", trackerSession.Restored))
+	}
+	if needRecheck {
+		h.setState(StateChecking)
+		restored, err := Recheck(ctx, &torrentFile, pieceArray, storage, runtime.NumCPU(), logRecheck)
+		if err != nil && ctx.Err() != nil {
+			// stopped while checking, nothing was changed yet
+			return nil
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Supervisor: "+err.Error())
+			h.fail(err)
+			return err
+		}
+		trackerSession.Restored = restored
+	}
+	// pieces of skipped files only are never downloaded, they are not left
+	trackerSession.Skipped = pieceArray.skippedBytes()
+	trackerSession.Left = h.totalBytes - trackerSession.Skipped - trackerSession.Restored
+
+	wgTracker.Go(func() { StartWorkerTracker(trackerCtx, trackerSession, traCh) })
+
+	// seeding time is counted on checks of seed limits
+	seedingTime := resumeData.SeedingTime
+	transfer := func() TransferStats {
+		uploaded, downloaded := trackerSession.Totals()
+		return newTransferStats(resumeData.Uploaded+uploaded, resumeData.Downloaded+downloaded, h.totalBytes-trackerSession.Skipped, seedingTime)
+	}
+	h.setTransfer(transfer())
+
+	saveResume := func() error {
+		if _, ok := storage.(diskStorage); !ok {
+			// data of other storages does not outlive the process
+			return nil
+		}
+		st := transfer()
+		rd, err := NewResumeData(onDisk, pieceArray, priorities, st.Uploaded, st.Downloaded)
+		if err == nil {
+			rd.SeedingTime = st.SeedingTime
+			err = WriteResume(resumePath, &rd)
+		}
+		if err != nil {
+			slog.Error("Supervisor: " + err.Error())
+		}
+		return err
+	}
+	resumeTicker := time.NewTicker(ResumeInterval)
+	defer resumeTicker.Stop()
+
+	// a file is synced and gets its final name once all its data is saved,
+	// the whole torrent is moved once all wanted files are
+	completed := newCompletion(&torrentFile, pieceArray, priorities)
+	savedCh := pieceArray.savedChan()
+	moved := false
+	syncCompleted := func() {
+		disk, isDisk := storage.(diskStorage)
+		for _, i := range completed.done() {
+			err := storage.Sync(file.Key{InfoHash: torrentFile.InfoHash, Index: i})
+			if err == nil && isDisk {
+				err = files.complete(disk, i)
+			}
+			if err != nil {
+				slog.Error("Supervisor: " + err.Error())
+			}
+		}
+		if isDisk && opts.moveTo != "" && !moved && completed.complete() {
+			moved = true
+			if err := files.move(disk, priorities); err != nil {
+				slog.Error("Supervisor: " + err.Error())
+			}
+		}
+	}
+	syncCompleted()
+
+	// activeState is the state of a torrent that is neither paused nor rechecked
+	activeState := func() TorrentState {
+		if completed.complete() {
+			return StateSeeding
+		}
+		return StateDownloading
+	}
+	h.setState(activeState())
 
-	for range 20 {
-		wgPiece.Go(func() { StartPieceWorker(ctx, &pieceArray, &torrentFile, fileMap, pieceCh) })
+	for range s.cfg.PieceWorkers {
+		wgPiece.Go(func() { StartPieceWorker(workCtx, pieceArray, &torrentFile, pieceCh) })
 	}
+	wgPiece.Go(func() { StartFlusher(workCtx, pieceArray, &torrentFile, pieceCh, &s.cfg) })
 
 	peerState := make(map[[6]byte]peerState)
 	tasksPeers := make(map[int][6]byte)
@@ -214,100 +384,336 @@ func StartSupervisor(ctx context.Context, torrentFile TorrentFile, port int) {
 	peerBitFields := make(map[[6]byte][]byte)
 	var peerQueue *util.List[[6]byte]
 
-	totalPeers := 20
+	totalPeers := s.cfg.Peers
 	availablePeers := totalPeers
 
+	// peer workers are stopped on pause, their context is replaced on resume
+	peersCtx, cancelPeers := context.WithCancel(ctx)
+	paused := false
+	pausedState := StatePaused // or StateQueued if paused by the queue
+
+	seedTicker := time.NewTicker(SeedCheckInterval)
+	defer seedTicker.Stop()
+	lastSeedCheck := time.Now()
+	seedDone := false
+
+	rechecking := false
+	var recheckReplies []chan<- error
+	type recheckResult struct {
+		diff int64
+		err  error
+	}
+	recheckDone := make(chan recheckResult)
+
+	// stats are sent from the loop, the tracker worker could be sending peers to us
+	var statsOut chan<- message.StatDiff
+	var pendingStats message.StatDiff
+	postStats := func(diff message.StatDiff) {
+		for i, v := range diff {
+			pendingStats[i] += v
+		}
+		statsOut = pieceCh.PostStatsChannel
+	}
+
+	// snubbed peers get a task again after a backoff, it is reset once they send data
+	snubCount := make(map[[6]byte]int)
+	snubRetry := make(chan [6]byte)
+
+	// assignTask gives a new task to a waiting peer, if there is one
+	assignTask := func(peer [6]byte) {
+		if rechecking || peerState[peer] != PeerWaiting {
+			return
+		}
+		task, err := findTask(pieceArray, peerBitFields[peer], int(pieceArray.pieceLength), tasksPeers, peerTasks, peer)
+		if err == nil {
+			peerState[peer] = PeerDownloading
+			ch.ToPeerWorkerToDownload[peer] <- task
+		}
+	}
+
+	// disconnectPeers stops all peer workers and forgets about peers, tasks
+	// they had are given to others later
+	disconnectPeers := func() {
+		cancelPeers()
+		for peer := range ch.ToPeerWorkerToDownload {
+			deadPeer(peer, &ch, peerTasks, tasksPeers)
+		}
+		for peer := range peerTasks {
+			resetTasks(pieceArray, peer, peerTasks, tasksPeers)
+		}
+		s.peers.release(totalPeers - availablePeers)
+		availablePeers = totalPeers
+		peerQueue = nil
+		clear(peerState)
+		clear(peerBitFields)
+		clear(snubCount)
+	}
+
+	// pause disconnects peers and stops announces, state is StatePaused or
+	// StateQueued
+	pause := func(state TorrentState) {
+		pausedState = state
+		if !paused {
+			slog.Info("Supervisor: paused")
+			paused = true
+			disconnectPeers()
+			trackerSession.setPaused(true)
+			pieceArray.flushAll()
+			saveResume()
+		}
+		if !rechecking {
+			h.setState(pausedState)
+		}
+	}
+
+	// shutdown stops the torrent, it gives up waiting for data to be written
+	// after Config.ShutdownTimeout
+	shutdown := func() error {
+		h.setState(StateStopping)
+		deadline := time.Now().Add(s.cfg.ShutdownTimeout)
+
+		// peers and the tracker may be blocked sending to the supervisor
+		// until they see they are stopped
+		drained := make(chan struct{})
+		defer close(drained)
+		go func() {
+			for {
+				select {
+				case <-ch.FromPeerWorker:
+				case <-ch.GetPeers:
+				case <-drained:
+					return
+				}
+			}
+		}()
+		disconnectPeers()
+
+		var errs []error
+		removing := h.removeData.Load()
+		if !removing {
+			pieceArray.flushAll()
+			for !pieceArray.flushed() && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if !pieceArray.flushed() {
+				errs = append(errs, errors.New("supervisor: validated data is not written before the deadline"))
+			}
+		}
+		stopWork()
+		wgPiece.Wait()
+
+		disk, isDisk := storage.(diskStorage)
+		switch {
+		case removing:
+			if err := files.remove(storage, priorities); err != nil {
+				errs = append(errs, err)
+			}
+			os.Remove(resumePath)
+		case isDisk:
+			// resume data is only written for data that reached the disk
+			if err := files.close(disk, priorities); err != nil {
+				errs = append(errs, err)
+			}
+			if err := saveResume(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		stopTracker()
+		wgTracker.Wait()
+		announceDeadline := time.Now().Add(AnnounceTimeout)
+		if deadline.Before(announceDeadline) {
+			announceDeadline = deadline
+		}
+		announceCtx, cancel := context.WithDeadline(context.Background(), announceDeadline)
+		trackerSession.stop(announceCtx)
+		cancel()
+
+		wgPeers.Wait()
+		return errors.Join(errs...)
+	}
+
 	for {
 		select {
 		case msg := <-ch.FromPeerWorker:
 			// slog.Info(fmt.Sprintf("Supervisor: received new message with type %d", msg.Id))
+			if _, ok := ch.ToPeerWorkerToDownload[msg.PeerId]; !ok {
+				// the peer was disconnected on pause, the message was sent before that
+				break
+			}
 			switch msg.Id {
 			case IdDead:
 				slog.Info("Supervisor: new dead")
 				peerState[msg.PeerId] = PeerDead
+				delete(snubCount, msg.PeerId)
 				deadPeer(msg.PeerId, &ch, peerTasks, tasksPeers)
-				resetTasks(&pieceArray, msg.PeerId, peerTasks, tasksPeers)
+				resetTasks(pieceArray, msg.PeerId, peerTasks, tasksPeers)
 
 				// Перераспределяем задачи ожидающим пирам
-				redistributed := redistributeTasksToWaiting(&pieceArray, peerState, peerBitFields, tasksPeers, peerTasks, ch.ToPeerWorkerToDownload)
-				if redistributed > 0 {
-					slog.Info(fmt.Sprintf("Supervisor: redistributed %d tasks from dead peer", redistributed))
+				if !rechecking {
+					redistributed := redistributeTasksToWaiting(pieceArray, peerState, peerBitFields, tasksPeers, peerTasks, ch.ToPeerWorkerToDownload)
+					if redistributed > 0 {
+						slog.Info(fmt.Sprintf("Supervisor: redistributed %d tasks from dead peer", redistributed))
+					}
 				}
 
 				availablePeers++
-				if peerQueue != nil {
+				s.peers.release(1)
+				if peerQueue != nil && s.peers.acquire() {
 					availablePeers--
-					newPeer(ctx, peerCh, peerQueue.Value, &pieceArray, torrentFile.InfoHash, trackerSession.PeerId, &wgPeers, &ch, &peerState)
+					newPeer(peersCtx, peerCh, peerQueue.Value, pieceArray, torrentFile.InfoHash, trackerSession.PeerId, bw, &s.cfg, &wgPeers, &ch, &peerState)
 					peerQueue = peerQueue.Next
 					if peerQueue != nil {
 						peerQueue.Prev = nil
 					}
 				}
-				queuePeer(msg.PeerId, peerState, traCh.SendPeers)
+				queuePeer(msg.PeerId, peerState, traCh.SendPeers, s.cfg.ReconnectDelay)
 				slog.Info(fmt.Sprintf("Supervisor: peers: %d", totalPeers-availablePeers))
 
+			case IdSnubbed:
+				slog.Info("Supervisor: peer snubbed")
+				peerState[msg.PeerId] = PeerSnubbed
+				resetTasks(pieceArray, msg.PeerId, peerTasks, tasksPeers)
+
+				peer := msg.PeerId
+				time.AfterFunc(s.cfg.SnubBackoff<<min(snubCount[peer], 4), func() {
+					select {
+					case snubRetry <- peer:
+					case <-ctx.Done():
+					}
+				})
+				snubCount[peer]++
+
+				if !rechecking {
+					redistributed := redistributeTasksToWaiting(pieceArray, peerState, peerBitFields, tasksPeers, peerTasks, ch.ToPeerWorkerToDownload)
+					if redistributed > 0 {
+						slog.Info(fmt.Sprintf("Supervisor: redistributed %d tasks from snubbed peer", redistributed))
+					}
+				}
+
+			case IdPiece:
+				// snubbed peer started sending data again
+				delete(snubCount, msg.PeerId)
+				if peerState[msg.PeerId] == PeerSnubbed {
+					peerState[msg.PeerId] = PeerWaiting
+					assignTask(msg.PeerId)
+				}
+
 			case IdBitfield:
 				if _, ok := peerBitFields[msg.PeerId]; !ok {
 					peerBitFields[msg.PeerId] = createBitField(len(pieceArray.pieces))
 				}
 				copy(peerBitFields[msg.PeerId], msg.Payload)
-				if peerState[msg.PeerId] == PeerWaiting {
-					task, err := findTask(&pieceArray, peerBitFields[msg.PeerId], int(pieceArray.pieceLength), tasksPeers, peerTasks, msg.PeerId)
-					if err == nil {
-						peerState[msg.PeerId] = PeerDownloading
-						ch.ToPeerWorkerToDownload[msg.PeerId] <- task
-					}
-				}
+				assignTask(msg.PeerId)
 
 			case IdHave:
 				if _, ok := peerBitFields[msg.PeerId]; !ok {
 					peerBitFields[msg.PeerId] = createBitField(len(pieceArray.pieces))
 				}
-				setPiece(int(msg.Payload[0]), peerBitFields[msg.PeerId])
-
-				if peerState[msg.PeerId] == PeerWaiting {
-					peerState[msg.PeerId] = PeerDownloading
-					newTask := message.DownloadRange{PieceLength: pieceArray.pieceLength, Offset: int64(msg.Payload[0]) * pieceArray.pieceLength, Length: pieceArray.pieceLength}
-					peerTasks[msg.PeerId] = newTask
-					tasksPeers[int(msg.Payload[0])] = msg.PeerId
-					ch.ToPeerWorkerToDownload[msg.PeerId] <- newTask
+				if len(msg.Payload) >= 4 {
+					index := int(binary.BigEndian.Uint32(msg.Payload))
+					if index < len(pieceArray.pieces) {
+						setPiece(index, peerBitFields[msg.PeerId])
+					}
 				}
+				assignTask(msg.PeerId)
 
 			case IdChoke:
 				peerState[msg.PeerId] = PeerChoking
-				resetTasks(&pieceArray, msg.PeerId, peerTasks, tasksPeers)
+				resetTasks(pieceArray, msg.PeerId, peerTasks, tasksPeers)
 
 			case IdUnchoke:
 				slog.Info("Supervisor: unchoke")
 				peerState[msg.PeerId] = PeerWaiting
-				if peerState[msg.PeerId] == PeerWaiting {
-					task, err := findTask(&pieceArray, peerBitFields[msg.PeerId], int(pieceArray.pieceLength), tasksPeers, peerTasks, msg.PeerId)
-					if err == nil {
-						peerState[msg.PeerId] = PeerDownloading
-						ch.ToPeerWorkerToDownload[msg.PeerId] <- task
-					}
-				}
+				assignTask(msg.PeerId)
 
 			case IdReady:
 				// slog.Info("Supervisor: isReady")
 				peerState[msg.PeerId] = PeerWaiting
-				// resetTasks(&pieceArray, msg.PeerId, peerTasks, tasksPeers)
-				if peerState[msg.PeerId] == PeerWaiting {
-					task, err := findTask(&pieceArray, peerBitFields[msg.PeerId], int(pieceArray.pieceLength), tasksPeers, peerTasks, msg.PeerId)
-					if err == nil {
-						peerState[msg.PeerId] = PeerDownloading
-						ch.ToPeerWorkerToDownload[msg.PeerId] <- task
+				// resetTasks(pieceArray, msg.PeerId, peerTasks, tasksPeers)
+				assignTask(msg.PeerId)
+			}
+
+		case peer := <-snubRetry:
+			if peerState[peer] == PeerSnubbed {
+				peerState[peer] = PeerWaiting
+				assignTask(peer)
+			}
+
+		case cmd := <-ch.Control:
+			switch cmd.Id {
+			case CommandRecheck:
+				recheckReplies = append(recheckReplies, cmd.Reply)
+				if rechecking {
+					break
+				}
+				slog.Info("Supervisor: recheck started")
+				rechecking = true
+				h.setState(StateChecking)
+				go func() {
+					diff, err := Recheck(ctx, &torrentFile, pieceArray, storage, runtime.NumCPU(), logRecheck)
+					select {
+					case recheckDone <- recheckResult{diff, err}:
+					case <-ctx.Done():
 					}
+				}()
+
+			case CommandPause, CommandQueue:
+				// the queue does not take over a torrent paused by the user
+				if cmd.Id == CommandPause {
+					pause(StatePaused)
+				} else if !paused {
+					pause(StateQueued)
+				}
+				if cmd.Reply != nil {
+					cmd.Reply <- nil
+				}
+
+			case CommandResume:
+				if paused {
+					slog.Info("Supervisor: resumed")
+					paused = false
+					peersCtx, cancelPeers = context.WithCancel(ctx)
+					// the tracker announces right away and sends peers
+					trackerSession.setPaused(false)
 				}
+				if !rechecking {
+					h.setState(activeState())
+				}
+				if cmd.Reply != nil {
+					cmd.Reply <- nil
+				}
+			}
+
+		case res := <-recheckDone:
+			rechecking = false
+			trackerSession.mu.Lock()
+			trackerSession.Restored += res.diff
+			trackerSession.mu.Unlock()
+			postStats(message.StatDiff{NotStarted: -res.diff, Saved: res.diff})
+			for _, reply := range recheckReplies {
+				if reply != nil {
+					reply <- res.err
+				}
+			}
+			recheckReplies = nil
+			if paused {
+				h.setState(pausedState)
+			} else {
+				h.setState(activeState())
 			}
+			redistributeTasksToWaiting(pieceArray, peerState, peerBitFields, tasksPeers, peerTasks, ch.ToPeerWorkerToDownload)
 
 		case p := <-ch.GetPeers:
 			// slog.Info("Supervisor: received peers")
+			if paused {
+				break
+			}
 			for _, i := range p {
 				if peerState[i] == PeerNotFound {
-					if availablePeers > 0 {
+					if availablePeers > 0 && s.peers.acquire() {
 						availablePeers--
-						newPeer(ctx, peerCh, i, &pieceArray, torrentFile.InfoHash, trackerSession.PeerId, &wgPeers, &ch, &peerState)
+						newPeer(peersCtx, peerCh, i, pieceArray, torrentFile.InfoHash, trackerSession.PeerId, bw, &s.cfg, &wgPeers, &ch, &peerState)
 					} else {
 						if peerQueue == nil {
 							peerQueue = &util.List[[6]byte]{Prev: nil, Next: nil, Value: i}
@@ -323,9 +729,49 @@ func StartSupervisor(ctx context.Context, torrentFile TorrentFile, port int) {
 				}
 			}
 
+		case statsOut <- pendingStats:
+			statsOut, pendingStats = nil, message.StatDiff{}
+
+		case <-resumeTicker.C:
+			saveResume()
+
+		case now := <-seedTicker.C:
+			if h.State() == StateSeeding {
+				seedingTime += now.Sub(lastSeedCheck)
+			}
+			lastSeedCheck = now
+			st := transfer()
+			h.setTransfer(st)
+			limits := s.seedLimitsOf(h)
+			if seedDone || h.State() != StateSeeding || !limits.reached(st) {
+				break
+			}
+			// the action is taken once, a torrent resumed by the user keeps seeding
+			seedDone = true
+			slog.Info(fmt.Sprintf("Supervisor: seed limits reached with ratio %.2f after %v, %v", st.Ratio, st.SeedingTime.Round(time.Second), limits.Action))
+			if limits.Action == SeedPause {
+				pause(StatePaused)
+				break
+			}
+			// removing waits for this supervisor to return
+			go s.Remove(torrentFile.InfoHash, limits.Action == SeedRemoveData)
+
+		case <-savedCh:
+			savedCh = pieceArray.savedChan()
+			syncCompleted()
+			if !paused && !rechecking {
+				h.setState(activeState())
+			}
+			// saved pieces free memory, peers held back by the budget get tasks again
+			if !rechecking {
+				redistributeTasksToWaiting(pieceArray, peerState, peerBitFields, tasksPeers, peerTasks, ch.ToPeerWorkerToDownload)
+			}
+
 		case <-ctx.Done():
-			return
+			cancelPeers()
+			return shutdown()
 		}
+		h.setPeers(len(ch.ToPeerWorkerToDownload), len(peerState))
 		// slog.Info("Supervisor: loop ended")
 	}
 }
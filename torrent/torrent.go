@@ -2,7 +2,10 @@ package torrent
 
 import (
 	"crypto/sha1"
+	"encoding/hex"
 	"errors"
+	"net/url"
+	"strconv"
 
 	"github.com/username918r818/torrent-client/util"
 )
@@ -42,9 +45,15 @@ func New(data []byte) (TorrentFile, error) {
 	if _, ok := (*be.Dict)["announce-list"]; ok {
 		tmp := (*be.Dict)["announce-list"]
 		if tmp.Tag != util.BeList || len(tmp.List) > 0 {
-			t.ReserveAnnounce = make([]string, len(tmp.List))
-			for i, v := range tmp.List {
-				t.ReserveAnnounce[i] = string(v.Str)
+			// tiers of trackers are lists, they are flattened in order
+			for _, v := range tmp.List {
+				if v.Tag != util.BeList {
+					t.ReserveAnnounce = append(t.ReserveAnnounce, string(v.Str))
+					continue
+				}
+				for _, w := range v.List {
+					t.ReserveAnnounce = append(t.ReserveAnnounce, string(w.Str))
+				}
 			}
 			hasAnnounces = true
 		}
@@ -111,3 +120,35 @@ func New(data []byte) (TorrentFile, error) {
 
 	return t, nil
 }
+
+// Name is the name of the file or the directory of the torrent.
+func (t *TorrentFile) Name() string {
+	if len(t.Files) == 0 || len(t.Files[0].Path) == 0 {
+		return ""
+	}
+	return t.Files[0].Path[0]
+}
+
+// Length returns bytes of all files together.
+func (t *TorrentFile) Length() int64 {
+	var n int64
+	for _, f := range t.Files {
+		n += f.Length
+	}
+	return n
+}
+
+// Magnet returns a magnet link of the torrent with its name, size and trackers.
+func (t *TorrentFile) Magnet() string {
+	link := "magnet:?xt=urn:btih:" + hex.EncodeToString(t.InfoHash[:])
+	link += "&dn=" + url.QueryEscape(t.Name())
+	link += "&xl=" + strconv.FormatInt(t.Length(), 10)
+	seen := make(map[string]bool)
+	for _, tracker := range append([]string{t.Announce}, t.ReserveAnnounce...) {
+		if tracker != "" && !seen[tracker] {
+			seen[tracker] = true
+			link += "&tr=" + url.QueryEscape(tracker)
+		}
+	}
+	return link
+}
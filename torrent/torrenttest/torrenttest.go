@@ -0,0 +1,62 @@
+// Package torrenttest builds small torrents for tests.
+package torrenttest
+
+import (
+	"crypto/sha1"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/username918r818/torrent-client/file"
+	"github.com/username918r818/torrent-client/torrent"
+)
+
+// Data is the payload of torrents made by NewTorrent.
+const Data = "0123456789"
+
+// NewTorrent returns a torrent of Data with pieces of 4 bytes over files a and b
+// in dir, of 6 and 4 bytes: the second piece is shared by both files.
+func NewTorrent(dir, a, b string) torrent.TorrentFile {
+	tf := torrent.TorrentFile{PieceLength: 4}
+	for i := 0; i < len(Data); i += 4 {
+		tf.Pieces = append(tf.Pieces, sha1.Sum([]byte(Data[i:min(i+4, len(Data))])))
+	}
+	tf.Files = []struct {
+		Length int64
+		Path   []string
+	}{
+		{Length: 6, Path: []string{dir, a}},
+		{Length: 4, Path: []string{dir, b}},
+	}
+	return tf
+}
+
+// WriteFiles creates files of tf with given contents, one per file.
+func WriteFiles(tf *torrent.TorrentFile, contents ...string) error {
+	for i, data := range contents {
+		path := filepath.Join(tf.Files[i].Path...)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenFiles opens existing files of tf for reading, missing files are left out.
+func OpenFiles(tf *torrent.TorrentFile) (*file.Disk, error) {
+	disk := file.NewDisk()
+	for i, f := range tf.Files {
+		opened, err := os.Open(filepath.Join(f.Path...))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		disk.Add(file.Key{InfoHash: tf.InfoHash, Index: i}, opened)
+	}
+	return disk, nil
+}
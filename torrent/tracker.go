@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/username918r818/torrent-client/message"
@@ -33,32 +33,85 @@ type TrackerSession struct {
 	Uploaded   int64
 	Downloaded int64
 	Left       int64
+	Restored   int64 // bytes restored from resume data, not downloaded in this session
+	Skipped    int64 // bytes of pieces that are not downloaded at all
+
+	mu     sync.Mutex // guards counters read by other goroutines, Event and paused
+	paused bool
+	wake   chan struct{} // announces right away once paused or resumed
 }
 
-func StartWorkerTracker(ctx context.Context, ts *TrackerSession, ch message.TrackerChannels) {
+func newTrackerSession() *TrackerSession {
+	return &TrackerSession{wake: make(chan struct{}, 1)}
+}
+
+// setPaused stops or restarts announces, the tracker is told about it right away.
+func (ts *TrackerSession) setPaused(paused bool) {
+	ts.mu.Lock()
+	if ts.paused == paused {
+		ts.mu.Unlock()
+		return
+	}
+	ts.paused = paused
 	ts.Event = EventStarted
+	if paused {
+		ts.Event = EventStopped
+	}
+	ts.mu.Unlock()
+
+	select {
+	case ts.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Totals returns uploaded and downloaded bytes counted in this session.
+func (ts *TrackerSession) Totals() (uploaded, downloaded int64) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.Uploaded, ts.Downloaded
+}
+
+func StartWorkerTracker(ctx context.Context, ts *TrackerSession, ch message.TrackerChannels) {
+	ts.mu.Lock()
+	if !ts.paused {
+		ts.Event = EventStarted
+	}
+	ts.mu.Unlock()
 	var stats [6]int64
 	for _, v := range ts.TorrentFile.Files {
 		stats[NotStarted] += v.Length
 
 	}
+	stats[NotStarted] -= ts.Restored + ts.Skipped
+	stats[Saved] += ts.Restored
 
 	for {
 		timer := time.NewTimer(time.Duration(ts.Interval) * time.Second)
 		select {
 		case <-timer.C:
-			ts.proceed(ch)
+			ts.mu.Lock()
+			paused := ts.paused
+			ts.mu.Unlock()
+			if !paused {
+				ts.proceed(ctx, ch)
+			}
+
+		case <-ts.wake:
+			timer.Stop()
+			ts.proceed(ctx, ch)
 
 		case statDiff := <-ch.GetStatsChannel:
 			for i, v := range statDiff {
 				stats[i] += v
 			}
+			ts.mu.Lock()
 			ts.Left = stats[NotStarted] + stats[Downloaded]
-			ts.Downloaded = stats[Validated] + stats[Saving] + stats[Saved]
-			if ts.Left == 0 {
+			ts.Downloaded = stats[Validated] + stats[Saving] + stats[Saved] - ts.Restored
+			if ts.Left == 0 && !ts.paused {
 				ts.Event = EventCompleted
 			}
-			slog.Info(fmt.Sprintf("0: %v, 1: %v, 2: %v, 3: %v, 4: %v, 5: %v", stats[0], stats[1], stats[2], stats[3], stats[4], stats[5]))
+			ts.mu.Unlock()
 
 		case <-ctx.Done():
 			return
@@ -66,7 +119,19 @@ func StartWorkerTracker(ctx context.Context, ts *TrackerSession, ch message.Trac
 	}
 }
 
-func (ts *TrackerSession) proceed(ch message.TrackerChannels) {
+// stop sends the stopped announce unless the torrent is paused and did it
+// already, the tracker worker has to be stopped before.
+func (ts *TrackerSession) stop(ctx context.Context) {
+	ts.mu.Lock()
+	paused := ts.paused
+	ts.Event = EventStopped
+	ts.mu.Unlock()
+	if !paused {
+		ts.proceed(ctx, message.TrackerChannels{})
+	}
+}
+
+func (ts *TrackerSession) proceed(ctx context.Context, ch message.TrackerChannels) {
 	url := ts.TorrentFile.Announce
 	infoHash := util.EncodeUrl(ts.TorrentFile.InfoHash[:])
 	sep := "?"
@@ -77,12 +142,16 @@ func (ts *TrackerSession) proceed(ch message.TrackerChannels) {
 	peer_id := util.EncodeUrl(ts.PeerId[:])
 	url += fmt.Sprintf("&peer_id=%v", peer_id)
 
+	ts.mu.Lock()
+	event := ts.Event
+	ts.Event = EventNone
 	url += fmt.Sprintf("&port=%v", ts.Port)
 	url += fmt.Sprintf("&uploaded=%v", ts.Uploaded)
 	url += fmt.Sprintf("&downloaded=%v", ts.Downloaded)
 	url += fmt.Sprintf("&left=%v", ts.Left)
+	ts.mu.Unlock()
 	url += fmt.Sprintf("&compact=%v", 1)
-	switch ts.Event {
+	switch event {
 	case EventStarted:
 		url += "&event=started"
 	case EventCompleted:
@@ -90,11 +159,19 @@ func (ts *TrackerSession) proceed(ch message.TrackerChannels) {
 	case EventStopped:
 		url += "&event=stopped"
 	}
-	ts.Event = EventNone
 
-	resp, err := http.Get(url)
+	// a failed announce must not bring down other torrents of the session
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		ts.Interval = 60
+		log.Printf("can't announce: %v", err)
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.Interval = 60
+		log.Printf("can't announce: %v", err)
+		return
 	}
 
 	defer resp.Body.Close()
@@ -102,18 +179,24 @@ func (ts *TrackerSession) proceed(ch message.TrackerChannels) {
 	if err != nil {
 		ts.Interval = 60
 		log.Printf("can't read body: %v", err)
+		return
 	}
 	be, err := util.Decode(body)
 
-	if err != nil {
+	if err != nil || be.Dict == nil {
 		ts.Interval = 60
 		log.Printf("can't decode bencode: %v", err)
+		return
 	}
 
 	fmt.Println(be.String())
 	fmt.Println(string((*be.Dict)["peers"].Str))
 
 	ts.Interval = int((*be.Dict)["interval"].Int)
+	if event == EventStopped {
+		// peers are not wanted until the torrent is resumed
+		return
+	}
 
 	peersBin := (*be.Dict)["peers"].Str
 	peers := make([][6]byte, len(peersBin)/6)
@@ -121,7 +204,11 @@ func (ts *TrackerSession) proceed(ch message.TrackerChannels) {
 		copy(peers[i][:], peersBin[i*6:(i+1)*6])
 	}
 
-	ch.SendPeers <- peers
+	// the supervisor does not read peers once it is stopped
+	select {
+	case ch.SendPeers <- peers:
+	case <-ctx.Done():
+	}
 
 }
 
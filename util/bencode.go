@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"strconv"
 )
 
 const (
@@ -179,6 +180,50 @@ func Decode(b []byte) (*Be, error) {
 	return d.decode()
 }
 
+// Encode returns bencoded form of be, dictionary keys are written in sorted order.
+func Encode(be *Be) []byte {
+	return be.appendTo(nil)
+}
+
+func (be *Be) appendTo(out []byte) []byte {
+	switch be.Tag {
+	case BeDict:
+		out = append(out, BeDict)
+		if be.Dict != nil {
+			keys := make([]string, 0, len(*be.Dict))
+			for k := range *be.Dict {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				out = strconv.AppendInt(out, int64(len(k)), 10)
+				out = append(out, ':')
+				out = append(out, k...)
+				v := (*be.Dict)[k]
+				out = v.appendTo(out)
+			}
+		}
+		return append(out, 'e')
+
+	case BeList:
+		out = append(out, BeList)
+		for i := range be.List {
+			out = be.List[i].appendTo(out)
+		}
+		return append(out, 'e')
+
+	case BeInt:
+		out = append(out, BeInt)
+		out = strconv.AppendInt(out, be.Int, 10)
+		return append(out, 'e')
+
+	default:
+		out = strconv.AppendInt(out, int64(len(be.Str)), 10)
+		out = append(out, ':')
+		return append(out, be.Str...)
+	}
+}
+
 func (be *Be) String() string {
 	switch {
 	case be.Tag == BeDict:
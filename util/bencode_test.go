@@ -79,6 +79,32 @@ func TestDecodeDict(t *testing.T) {
 	}
 }
 
+func TestEncode(t *testing.T) {
+	t.Run("sorted dict keys", func(t *testing.T) {
+		dict := map[string]util.Be{
+			"spam": {Tag: util.BeStr, Str: []byte("eggs")},
+			"cow":  {Tag: util.BeStr, Str: []byte("moo")},
+		}
+		got := string(util.Encode(&util.Be{Tag: util.BeDict, Dict: &dict}))
+		expected := "d3:cow3:moo4:spam4:eggse"
+		if got != expected {
+			t.Errorf("expected %s, got %s", expected, got)
+		}
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		data := []byte("d4:infod6:lengthi-12345e4:name11:example.txte4:listli1ei2e0:ee")
+		b, err := util.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := util.Encode(b)
+		if string(got) != string(data) {
+			t.Errorf("expected %s, got %s", data, got)
+		}
+	})
+}
+
 func TestDecodeEmpty(t *testing.T) {
 	_, err := util.Decode([]byte(""))
 	if err == nil {